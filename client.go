@@ -0,0 +1,397 @@
+package zkm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrBindTimeout = errors.New("timeout wait for bind response")
+
+type BindError struct {
+	Status Status
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("bind failed: [%v]", e.Status)
+}
+
+type ClientConfig struct {
+	Network                string
+	Addr                   string
+	BindId                 Id
+	SystemID               string
+	Password               string
+	SystemType             string
+	AddrTON                uint8
+	AddrNPI                uint8
+	AddressRange           string
+	OutbindSystemID        string
+	OutbindPassword        string
+	TLSConfig              *tls.Config
+	DialTimeout            time.Duration
+	BindTimeout            time.Duration
+	ReconnectMinDelay      time.Duration
+	ReconnectMaxDelay      time.Duration
+	SessionConfig          *SessionConfig
+	SpeedControllerFactory func() SpeedController
+	Capture                *PcapWriter
+	// RecorderFactory gives each connection its own recording, it's closed when the session ends
+	RecorderFactory func(conn net.Conn) *Recorder
+}
+
+func NewDefaultClientConfig(addr, systemID, password string) *ClientConfig {
+	return &ClientConfig{
+		Network:           "tcp",
+		Addr:              addr,
+		BindId:            BindTransceiver,
+		SystemID:          systemID,
+		Password:          password,
+		DialTimeout:       5 * time.Second,
+		BindTimeout:       5 * time.Second,
+		ReconnectMinDelay: 500 * time.Millisecond,
+		ReconnectMaxDelay: 30 * time.Second,
+		SessionConfig:     NewDefaultSessionConfig(),
+		SpeedControllerFactory: func() SpeedController {
+			return NewDefaultSpeedController(Robust)
+		},
+	}
+}
+
+type Client struct {
+	cfg       *ClientConfig
+	l         net.Listener
+	outReqCh  chan *Req
+	outRespCh chan *Pdu
+	inRespCh  chan *Resp
+	inReqCh   chan *Pdu
+	evtCh     chan Evt
+	session   *Session
+	mu        sync.Mutex
+}
+
+func NewClient(cfg *ClientConfig) *Client {
+	return &Client{
+		cfg:       cfg,
+		outReqCh:  make(chan *Req),
+		outRespCh: make(chan *Pdu, chanBuffSize),
+		inRespCh:  make(chan *Resp, chanBuffSize),
+		inReqCh:   make(chan *Pdu, chanBuffSize),
+		evtCh:     make(chan Evt, chanBuffSize),
+	}
+}
+
+func (c *Client) InRespCh() <-chan *Resp {
+	return c.inRespCh
+}
+
+func (c *Client) InReqCh() <-chan *Pdu {
+	return c.inReqCh
+}
+
+func (c *Client) InEvtCh() <-chan Evt {
+	return c.evtCh
+}
+
+func (c *Client) OutReqCh() chan<- *Req {
+	return c.outReqCh
+}
+
+func (c *Client) OutRespCh() chan<- *Pdu {
+	return c.outRespCh
+}
+
+func (c *Client) Session() *Session {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+func (c *Client) Run(ctx context.Context) {
+	attempt := 0
+
+	for {
+		bound, err := c.runSession(ctx)
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if bound {
+			attempt = 0
+		}
+
+		if err != nil {
+			c.logEvt(Error, func() string {
+				return fmt.Sprintf("session to [%v] failed: [%v]", c.addr(), err)
+			})
+			c.errEvt(err)
+		}
+
+		delay := c.reconnectDelay(attempt)
+		attempt++
+
+		c.logEvt(Info, func() string {
+			return fmt.Sprintf("reconnecting to [%v] in [%v]", c.addr(), delay)
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	c.logEvt(Debug, func() string {
+		return "client completed"
+	})
+
+	close(c.evtCh)
+	close(c.inRespCh)
+	close(c.inReqCh)
+}
+
+func (c *Client) reconnectDelay(attempt int) time.Duration {
+	delay := c.cfg.ReconnectMinDelay
+	for i := 0; i < attempt && delay < c.cfg.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > c.cfg.ReconnectMaxDelay {
+		delay = c.cfg.ReconnectMaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if c.l != nil {
+		return c.acceptOutbind(ctx)
+	}
+
+	dialer := net.Dialer{Timeout: c.cfg.DialTimeout}
+	conn, err := dialer.DialContext(ctx, c.cfg.Network, c.cfg.Addr)
+	if err != nil || c.cfg.TLSConfig == nil {
+		return conn, err
+	}
+
+	tlsConn := tls.Client(conn, clientTLSConfig(c.cfg.TLSConfig, c.cfg.Addr))
+	if err := handshakeTLS(tlsConn, c.cfg.DialTimeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+func (c *Client) addr() string {
+	if c.l != nil {
+		return c.l.Addr().String()
+	}
+
+	return c.cfg.Addr
+}
+
+func (c *Client) bindId() Id {
+	if c.l != nil {
+		return BindReceiver
+	}
+
+	return c.cfg.BindId
+}
+
+func (c *Client) createBindPdu() (*Pdu, error) {
+	pdu := NewPdu(c.bindId())
+
+	if err := pdu.SetMain(SystemID, c.cfg.SystemID); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(Password, c.cfg.Password); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(SystemType, c.cfg.SystemType); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(InterfaceVersion, 0x34); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(AddrTON, c.cfg.AddrTON); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(AddrNPI, c.cfg.AddrNPI); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(AddressRange, c.cfg.AddressRange); err != nil {
+		return nil, err
+	}
+
+	return pdu, nil
+}
+
+func (c *Client) runSession(ctx context.Context) (bool, error) {
+	switch c.bindId() {
+	case BindTransmitter, BindReceiver, BindTransceiver:
+	default:
+		return false, fmt.Errorf("bad bind id [%v]", c.bindId())
+	}
+
+	bindPdu, err := c.createBindPdu()
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	sessionCfg := *c.cfg.SessionConfig
+	sock := NewSock(conn)
+	if c.cfg.Capture != nil {
+		sock.SetCapture(c.cfg.Capture)
+	}
+	var recorder *Recorder
+	if c.cfg.RecorderFactory != nil {
+		if recorder = c.cfg.RecorderFactory(conn); recorder != nil {
+			sock.SetRecorder(recorder)
+		}
+	}
+	session := NewSessionWithConfig(sock, &sessionCfg, c.cfg.SpeedControllerFactory())
+	bindReq := &Req{Pdu: bindPdu, Timeout: c.cfg.BindTimeout}
+	bindRespCh := make(chan *Resp, 1)
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	go func() {
+		defer close(done)
+		session.Run(sessionCtx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+		wg.Wait()
+		if recorder != nil {
+			_ = recorder.Close()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for evt := range session.InEvtCh() {
+			c.evtCh <- evt
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for pdu := range session.InReqCh() {
+			c.inReqCh <- pdu
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for resp := range session.InRespCh() {
+			if resp.Req == bindReq {
+				bindRespCh <- resp
+			} else {
+				c.inRespCh <- resp
+			}
+		}
+	}()
+
+	bindTimer := time.NewTimer(c.cfg.BindTimeout)
+	defer bindTimer.Stop()
+
+	select {
+	case session.OutReqCh() <- bindReq:
+	case <-bindTimer.C:
+		return false, ErrBindTimeout
+	case <-done:
+		return false, ErrClosed
+	}
+
+	select {
+	case resp := <-bindRespCh:
+		if resp.Err == ErrTimeout {
+			return false, ErrBindTimeout
+		}
+		if resp.Err != nil {
+			return false, resp.Err
+		}
+		if resp.Pdu.Status() != EsmeROk {
+			return false, &BindError{Status: resp.Pdu.Status()}
+		}
+	case <-bindTimer.C:
+		return false, ErrBindTimeout
+	case <-done:
+		return false, ErrClosed
+	}
+
+	c.logEvt(Info, func() string {
+		return fmt.Sprintf("bound to [%v] as [%v]", session.RemoteAddr(), c.bindId())
+	})
+
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.session = nil
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case r := <-c.outReqCh:
+			select {
+			case session.OutReqCh() <- r:
+			case <-done:
+				c.inRespCh <- &Resp{
+					Err: ErrClosed,
+					Req: r,
+				}
+				return true, nil
+			}
+		case pdu := <-c.outRespCh:
+			select {
+			case session.OutRespCh() <- pdu:
+			case <-done:
+				return true, nil
+			}
+		case <-done:
+			return true, nil
+		}
+	}
+}
+
+func (c *Client) logEvt(severity Severity, msgCreator func() string) {
+	if severity < c.cfg.SessionConfig.LogSeverity {
+		return
+	}
+
+	c.evtCh <- &LogEvt{severity: severity, msg: msgCreator()}
+}
+
+func (c *Client) errEvt(err error) {
+	c.evtCh <- &ErrEvt{err: err}
+}
@@ -0,0 +1,138 @@
+package zkm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cfg := NewDefaultClientConfig(l.Addr().String(), "login", "pass")
+	cfg.BindId = BindTransmitter
+	cfg.ReconnectMinDelay = 10 * time.Millisecond
+	cfg.ReconnectMaxDelay = 20 * time.Millisecond
+	cfg.SessionConfig.OutRpsLimit = 100
+	client := NewClient(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+	go func() {
+		for range client.InEvtCh() {
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sock := NewSock(conn)
+
+		bind, err := sock.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bind.Id() != BindTransmitter {
+			t.Fatalf("[%v] unexpected bind [%v]", i, bind)
+		}
+		if systemID, _ := bind.GetMainAsString(SystemID); systemID != "login" {
+			t.Errorf("[%v] system id [%v] not equals expected [login]", i, systemID)
+		}
+		bindResp, _ := bind.CreateResp(EsmeROk)
+		if err := sock.Write(bindResp); err != nil {
+			t.Fatal(err)
+		}
+
+		req := &Req{Pdu: NewPdu(SubmitSm), Ctx: i}
+		select {
+		case client.OutReqCh() <- req:
+		case <-time.After(time.Second):
+			t.Fatalf("[%v] req not accepted", i)
+		}
+
+		submit, err := sock.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		submitResp, _ := submit.CreateResp(EsmeROk)
+		if err := sock.Write(submitResp); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case resp := <-client.InRespCh():
+			if resp.Err != nil || resp.Req.Ctx != i || resp.Pdu.Id() != SubmitSmResp {
+				t.Errorf("[%v] unexpected resp [%v][%v]", i, resp.Err, resp.Pdu)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%v] resp not received", i)
+		}
+
+		_ = conn.Close()
+	}
+
+	cancel()
+	<-done
+}
+
+func TestClientBindTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cfg := NewDefaultClientConfig(l.Addr().String(), "login", "pass")
+	cfg.BindTimeout = time.Second
+	cfg.SessionConfig.ReqTimeout = 50 * time.Millisecond
+	client := NewClient(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		for range client.InEvtCh() {
+		}
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sock := NewSock(conn)
+
+	bind, err := sock.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	bindResp, _ := bind.CreateResp(EsmeROk)
+	if err := sock.Write(bindResp); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(time.Second); client.Session() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("bind resp slower than req timeout but within bind timeout not accepted")
+		}
+	}
+}
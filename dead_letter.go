@@ -0,0 +1,169 @@
+package zkm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type DeadLetter struct {
+	Pdu       []byte    `json:"pdu"`
+	Status    Status    `json:"status"`
+	Err       string    `json:"err,omitempty"`
+	Attempts  int32     `json:"attempts"`
+	TraceInfo string    `json:"trace_info,omitempty"`
+	Sent      time.Time `json:"sent"`
+	Failed    time.Time `json:"failed"`
+}
+
+func newDeadLetter(resp *Resp, now time.Time) *DeadLetter {
+	dl := &DeadLetter{
+		Pdu:       resp.Req.Pdu.Serialize(),
+		Attempts:  resp.Req.retries,
+		TraceInfo: resp.Req.TraceInfo,
+		Sent:      resp.Req.Sent,
+		Failed:    now,
+	}
+
+	if resp.Err != nil {
+		dl.Err = resp.Err.Error()
+	} else if resp.Pdu != nil {
+		dl.Status = resp.Pdu.Status()
+	}
+
+	return dl
+}
+
+func (dl *DeadLetter) Req() (*Req, error) {
+	pdu := NewEmptyPdu()
+	if err := pdu.Deserialize(dl.Pdu); err != nil {
+		return nil, err
+	}
+
+	return &Req{
+		Pdu:       pdu,
+		TraceInfo: dl.TraceInfo,
+	}, nil
+}
+
+type DeadLetterSink interface {
+	Put(dl *DeadLetter) error
+}
+
+type FileDeadLetterSink struct {
+	f  *os.File
+	mu sync.Mutex
+}
+
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetterSink{f: f}, nil
+}
+
+func (s *FileDeadLetterSink) Put(dl *DeadLetter) error {
+	line, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
+
+func ReadDeadLetters(path string) ([]*DeadLetter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []*DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		dl := &DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), dl); err != nil {
+			return letters, err
+		}
+		letters = append(letters, dl)
+	}
+
+	return letters, scanner.Err()
+}
+
+func (s *Session) Reinject(ctx context.Context, dl *DeadLetter) error {
+	req, err := dl.Req()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.outReqCh <- req:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.done:
+		return ErrClosed
+	}
+}
+
+func (s *Session) deadLetter(resp *Resp) {
+	if s.cfg.DeadLetterSink == nil || resp.Req == nil || !isDeadLetterCandidate(resp) {
+		return
+	}
+
+	select {
+	case s.deadLetterCh <- newDeadLetter(resp, time.Now()):
+	default:
+		s.errEvt(fmt.Errorf("queue of dead letters full: %v", len(s.deadLetterCh)))
+	}
+}
+
+func (s *Session) handleDeadLetters() {
+	for dl := range s.deadLetterCh {
+		if err := s.cfg.DeadLetterSink.Put(dl); err != nil {
+			s.errEvt(err)
+		}
+	}
+}
+
+// isDeadLetterCandidate accepts requests which timed out, were lost with the session or the full queue of retries,
+// or ran out of attempts on a status the retry policy retries. Requests the pool fails over are left to it.
+func isDeadLetterCandidate(resp *Resp) bool {
+	switch resp.Req.Pdu.Id() {
+	case EnquireLink, Unbind, BindReceiver, BindTransmitter, BindTransceiver:
+		return false
+	}
+
+	if resp.Req.sendCtx != nil && resp.Req.sendCtx.Err() != nil || failoverable(resp) {
+		return false
+	}
+
+	if resp.Err != nil {
+		return resp.Err == ErrTimeout || resp.Err == ErrClosed || resp.Err == ErrRetriesQueueFull
+	}
+
+	return resp.Pdu != nil && resp.Pdu.Status() != EsmeROk && resp.Req.retryable
+}
@@ -0,0 +1,170 @@
+package zkm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dead_letters")
+	sink, err := NewFileDeadLetterSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.ThrottleRetriesMaxCount = 1
+	cfg.DeadLetterSink = sink
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	for _, status := range []Status{EsmeRInvDstAdr, EsmeRThrottled} {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(DestinationAddr, "79001234567")
+		session.OutReqCh() <- &Req{Pdu: pdu, TraceInfo: status.String()}
+
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := req.CreateResp(status)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case r := <-session.InRespCh():
+			if r.Pdu.Status() != status {
+				t.Errorf("status [%v] not equals expected [%v]", r.Pdu.Status(), status)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("resp not received")
+		}
+	}
+
+	var letters []*DeadLetter
+	for deadline := time.Now().Add(time.Second); len(letters) == 0 && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if letters, err = ReadDeadLetters(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(letters) != 1 {
+		t.Fatalf("dead letters [%v] not equals expected [1]", len(letters))
+	}
+	dl := letters[0]
+	if dl.Status != EsmeRThrottled || dl.Attempts != 1 || dl.TraceInfo != EsmeRThrottled.String() || dl.Sent.IsZero() {
+		t.Errorf("unexpected dead letter [%+v]", dl)
+	}
+
+	if err := session.Reinject(context.Background(), dl); err != nil {
+		t.Fatal(err)
+	}
+	req, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := req.GetMainAsString(DestinationAddr); req.Id() != SubmitSm || addr != "79001234567" {
+		t.Errorf("unexpected reinjected pdu [%v]", req)
+	}
+}
+
+type countingRetryPolicy struct {
+	maxAttempts int32
+	calls       int32
+}
+
+func (p *countingRetryPolicy) Retry(status Status, err error, attempt int32) (time.Duration, bool) {
+	atomic.AddInt32(&p.calls, 1)
+	return 0, status == EsmeRThrottled && attempt < p.maxAttempts
+}
+
+func TestDeadLetterRetryPolicy(t *testing.T) {
+	counting := &countingRetryPolicy{maxAttempts: 2}
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempts int
+	}{
+		{"fixed without attempts", NewFixedDelayRetryPolicy(0, 0), 1},
+		{"without classifier", counting, 2},
+	}
+
+	for _, test := range tests {
+		sink := &countingDeadLetterSink{}
+		cfg := NewDefaultSessionConfig()
+		cfg.OutRpsLimit = 100
+		cfg.RetryPolicy = test.policy
+		cfg.DeadLetterSink = sink
+		session, peer, cancel, done := newTestSession(cfg)
+		bindTestSession(t, session, peer)
+
+		session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+		for i := 0; i < test.attempts; i++ {
+			req, err := peer.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, _ := req.CreateResp(EsmeRThrottled)
+			if err := peer.Write(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		select {
+		case r := <-session.InRespCh():
+			if r.Pdu == nil || r.Pdu.Status() != EsmeRThrottled {
+				t.Errorf("[%v] resp [%v][%v] not equals expected [%v]", test.name, r.Pdu, r.Err, EsmeRThrottled)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%v] resp not received", test.name)
+		}
+
+		cancel()
+		<-done
+		if count := atomic.LoadInt32(&sink.count); count != 1 {
+			t.Errorf("[%v] dead letters [%v] not equals expected [1]", test.name, count)
+		}
+	}
+
+	if calls := atomic.LoadInt32(&counting.calls); calls != 3 {
+		t.Errorf("policy calls [%v] not equals expected [3], bind resp and two throttled resps", calls)
+	}
+}
+
+func TestIsDeadLetterCandidate(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{ErrTimeout, true},
+		{ErrClosed, true},
+		{ErrRetriesQueueFull, true},
+		{ErrInvalidBindState, false},
+	}
+
+	for _, test := range tests {
+		resp := &Resp{Err: test.err, Req: &Req{Pdu: NewPdu(SubmitSm)}}
+		if candidate := isDeadLetterCandidate(resp); candidate != test.expected {
+			t.Errorf("[%v] candidate [%v] not equals expected [%v]", test.err, candidate, test.expected)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package zkm
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	queueRecordAdd  byte = 'A'
+	queueRecordDone byte = 'D'
+
+	queueRecordHeaderSize = 1 + 8 + 4
+
+	// log is compacted once it holds this many records of completed entries and they outnumber live ones
+	queueCompactThreshold = 1024
+)
+
+var ErrQueueClosed = errors.New("queue closed")
+var ErrQueueAttemptsExhausted = errors.New("queue attempts exhausted")
+
+// DurableQueue delivers at least once: done records aren't synced, so entries completed
+// right before a crash are sent again after it. MaxAttempts < 1 retries failed sends forever,
+// otherwise an entry failing that many times is completed with its last response and put to DeadLetterSink.
+type DurableQueueConfig struct {
+	Path           string
+	MaxInFlight    int32
+	MaxAttempts    int32
+	RetryDelay     time.Duration
+	Dedupe         func(pdu *Pdu) bool
+	DeadLetterSink DeadLetterSink
+}
+
+func NewDefaultDurableQueueConfig(path string) *DurableQueueConfig {
+	return &DurableQueueConfig{
+		Path:        path,
+		MaxInFlight: 100,
+		MaxAttempts: 10,
+		RetryDelay:  time.Second,
+	}
+}
+
+type queueEntry struct {
+	id       uint64
+	pdu      *Pdu
+	attempts int32
+}
+
+type DurableQueue struct {
+	cfg      *DurableQueueConfig
+	f        *os.File
+	size     int64
+	dead     int
+	nextId   uint64
+	live     map[uint64]*queueEntry
+	pending  []*queueEntry
+	replayed map[uint64]bool
+	signal   chan struct{}
+	respCh   chan *Resp
+	closed   bool
+	mu       sync.Mutex
+}
+
+func OpenDurableQueue(cfg *DurableQueueConfig) (*DurableQueue, error) {
+	entries, nextId, err := loadQueue(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &DurableQueue{
+		cfg:      cfg,
+		nextId:   nextId,
+		live:     make(map[uint64]*queueEntry, len(entries)),
+		pending:  entries,
+		replayed: make(map[uint64]bool, len(entries)),
+		signal:   make(chan struct{}, 1),
+		respCh:   make(chan *Resp, chanBuffSize),
+	}
+
+	for _, e := range entries {
+		q.live[e.id] = e
+		q.replayed[e.id] = true
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// compact must be called with q.mu locked.
+func (q *DurableQueue) compact() error {
+	entries := make([]*queueEntry, 0, len(q.live))
+	for _, e := range q.live {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].id < entries[j].id })
+
+	if err := compactQueue(q.cfg.Path, entries); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(q.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if q.f != nil {
+		_ = q.f.Close()
+	}
+	q.f = f
+	q.size = info.Size()
+	q.dead = 0
+	return nil
+}
+
+// append must be called with q.mu locked. A failed write is cut off, so a torn record can't hide the ones after it.
+func (q *DurableQueue) append(record []byte) error {
+	n, err := q.f.Write(record)
+	if err != nil {
+		if n > 0 {
+			_ = q.f.Truncate(q.size)
+		}
+		return err
+	}
+
+	q.size += int64(n)
+	return nil
+}
+
+func loadQueue(path string) ([]*queueEntry, uint64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 1, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var order []uint64
+	entries := make(map[uint64]*queueEntry)
+	var nextId uint64 = 1
+
+	r := bufio.NewReader(f)
+	header := make([]byte, queueRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+
+		id := binary.BigEndian.Uint64(header[1:9])
+		data := make([]byte, binary.BigEndian.Uint32(header[9:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+
+		if id >= nextId {
+			nextId = id + 1
+		}
+
+		switch header[0] {
+		case queueRecordAdd:
+			pdu := NewEmptyPdu()
+			if err := pdu.Deserialize(data); err != nil {
+				continue
+			}
+			entries[id] = &queueEntry{id: id, pdu: pdu}
+			order = append(order, id)
+		case queueRecordDone:
+			delete(entries, id)
+		}
+	}
+
+	var pending []*queueEntry
+	for _, id := range order {
+		if e, ok := entries[id]; ok {
+			pending = append(pending, e)
+		}
+	}
+
+	return pending, nextId, nil
+}
+
+func compactQueue(path string, entries []*queueEntry) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		if _, err := w.Write(queueRecord(queueRecordAdd, e.id, e.pdu.Serialize())); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func queueRecord(op byte, id uint64, data []byte) []byte {
+	record := make([]byte, queueRecordHeaderSize+len(data))
+	record[0] = op
+	binary.BigEndian.PutUint64(record[1:9], id)
+	binary.BigEndian.PutUint32(record[9:], uint32(len(data)))
+	copy(record[queueRecordHeaderSize:], data)
+	return record
+}
+
+func (q *DurableQueue) Put(pdu *Pdu) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0, ErrQueueClosed
+	}
+
+	id := q.nextId
+	if err := q.append(queueRecord(queueRecordAdd, id, pdu.Serialize())); err != nil {
+		return 0, err
+	}
+
+	if err := q.f.Sync(); err != nil {
+		return 0, err
+	}
+
+	q.nextId++
+	e := &queueEntry{id: id, pdu: pdu}
+	q.live[id] = e
+	q.pending = append(q.pending, e)
+	q.notify()
+
+	return id, nil
+}
+
+func (q *DurableQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.pending)
+}
+
+func (q *DurableQueue) RespCh() <-chan *Resp {
+	return q.respCh
+}
+
+func (q *DurableQueue) Run(ctx context.Context, send func(ctx context.Context, pdu *Pdu) (*Resp, error)) {
+	maxInFlight := q.cfg.MaxInFlight
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	sema := make(chan struct{}, maxInFlight)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		e := q.next()
+		if e == nil {
+			select {
+			case <-q.signal:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case sema <- struct{}{}:
+		case <-ctx.Done():
+			q.requeue(e)
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				<-sema
+			}()
+			q.send(ctx, e, send)
+		}()
+	}
+}
+
+func (q *DurableQueue) send(ctx context.Context, e *queueEntry, send func(ctx context.Context, pdu *Pdu) (*Resp, error)) {
+	q.mu.Lock()
+	replayed := q.replayed[e.id]
+	delete(q.replayed, e.id)
+	q.mu.Unlock()
+
+	if replayed && q.cfg.Dedupe != nil && q.cfg.Dedupe(e.pdu) {
+		_ = q.done(e)
+		return
+	}
+
+	resp, err := send(ctx, e.pdu)
+	if err != nil || resp == nil || resp.Pdu == nil {
+		if e.attempts++; q.cfg.MaxAttempts > 0 && e.attempts >= q.cfg.MaxAttempts {
+			q.exhausted(ctx, e, resp, err)
+			return
+		}
+
+		select {
+		case <-time.After(q.cfg.RetryDelay):
+		case <-ctx.Done():
+		}
+		q.requeue(e)
+		return
+	}
+
+	if err := q.done(e); err != nil {
+		resp.Err = err
+	}
+
+	select {
+	case q.respCh <- resp:
+	case <-ctx.Done():
+	}
+}
+
+func (q *DurableQueue) exhausted(ctx context.Context, e *queueEntry, resp *Resp, err error) {
+	if resp == nil {
+		resp = &Resp{}
+	}
+	if resp.Req == nil {
+		resp.Req = &Req{Pdu: e.pdu}
+	}
+	if err != nil {
+		resp.Err = err
+	} else if resp.Err == nil {
+		resp.Err = ErrQueueAttemptsExhausted
+	}
+
+	// without its dead letter the entry stays in the log and is sent again after reopening
+	var putErr error
+	if q.cfg.DeadLetterSink != nil {
+		dl := newDeadLetter(resp, time.Now())
+		dl.Attempts = e.attempts
+		putErr = q.cfg.DeadLetterSink.Put(dl)
+	}
+
+	if putErr != nil {
+		resp.Err = putErr
+	} else if err := q.done(e); err != nil {
+		resp.Err = err
+	}
+
+	select {
+	case q.respCh <- resp:
+	case <-ctx.Done():
+	}
+}
+
+func (q *DurableQueue) next() *queueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil
+	}
+
+	e := q.pending[0]
+	q.pending = q.pending[1:]
+	return e
+}
+
+func (q *DurableQueue) requeue(e *queueEntry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, e)
+	q.notify()
+}
+
+func (q *DurableQueue) done(e *queueEntry) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	if err := q.append(queueRecord(queueRecordDone, e.id, nil)); err != nil {
+		return err
+	}
+
+	delete(q.live, e.id)
+	q.dead += 2
+	if q.dead >= queueCompactThreshold && q.dead > len(q.live) {
+		return q.compact()
+	}
+
+	return nil
+}
+
+func (q *DurableQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DurableQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	q.closed = true
+	return q.f.Close()
+}
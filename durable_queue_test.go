@@ -0,0 +1,232 @@
+package zkm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDurableQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewDefaultDurableQueueConfig(filepath.Join(dir, "queue"))
+	cfg.RetryDelay = time.Millisecond
+	cfg.Dedupe = func(pdu *Pdu) bool {
+		addr, _ := pdu.GetMainAsString(DestinationAddr)
+		return addr == "2"
+	}
+
+	q, err := OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{"1", "2", "3"} {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(DestinationAddr, addr)
+		if _, err := q.Put(pdu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = q.Close()
+
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{queueRecordAdd, 0, 0})
+	_ = f.Close()
+
+	q, err = OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("recovered [%v] not equals expected [3]", q.Len())
+	}
+
+	pdu := NewPdu(SubmitSm)
+	_ = pdu.SetMain(DestinationAddr, "4")
+	if _, err := q.Put(pdu); err != nil {
+		t.Fatal(err)
+	}
+
+	mu := sync.Mutex{}
+	attempts := make(map[string]int)
+	send := func(ctx context.Context, pdu *Pdu) (*Resp, error) {
+		addr, _ := pdu.GetMainAsString(DestinationAddr)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[addr]++
+		if addr == "3" && attempts[addr] == 1 {
+			return nil, ErrClosed
+		}
+		resp, _ := pdu.CreateResp(EsmeROk)
+		return &Resp{Pdu: resp, Req: &Req{Pdu: pdu}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx, send)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-q.RespCh():
+		case <-time.After(time.Second):
+			t.Fatal("resp not received")
+		}
+	}
+	cancel()
+	<-done
+	_ = q.Close()
+
+	expected := map[string]int{"1": 1, "3": 2, "4": 1}
+	for addr, n := range expected {
+		if attempts[addr] != n {
+			t.Errorf("attempts for [%v] [%v] not equals expected [%v]", addr, attempts[addr], n)
+		}
+	}
+	if attempts["2"] != 0 {
+		t.Errorf("deduplicated pdu sent [%v] times", attempts["2"])
+	}
+
+	q, err = OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 0 {
+		t.Errorf("pending [%v] not equals expected [0]", q.Len())
+	}
+}
+
+func TestDurableQueueCompactsWhileRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewDefaultDurableQueueConfig(filepath.Join(dir, "queue"))
+	cfg.MaxInFlight = 0
+
+	q, err := OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	const count = 600
+	for i := 0; i < count; i++ {
+		if _, err := q.Put(NewPdu(SubmitSm)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := info.Size()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx, func(ctx context.Context, pdu *Pdu) (*Resp, error) {
+			resp, _ := pdu.CreateResp(EsmeROk)
+			return &Resp{Pdu: resp, Req: &Req{Pdu: pdu}}, nil
+		})
+	}()
+
+	for i := 0; i < count; i++ {
+		select {
+		case <-q.RespCh():
+		case <-time.After(time.Second):
+			t.Fatalf("resp [%v] not received", i)
+		}
+	}
+	cancel()
+	<-done
+
+	if info, err = os.Stat(cfg.Path); err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= full/2 {
+		t.Errorf("log size [%v] not compacted, size after puts [%v]", info.Size(), full)
+	}
+}
+
+func TestDurableQueueMaxAttempts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &countingDeadLetterSink{}
+	cfg := NewDefaultDurableQueueConfig(filepath.Join(dir, "queue"))
+	cfg.RetryDelay = time.Millisecond
+	cfg.MaxAttempts = 3
+	cfg.DeadLetterSink = sink
+
+	q, err := OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Put(NewPdu(SubmitSm)); err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts int32
+	send := func(ctx context.Context, pdu *Pdu) (*Resp, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &Resp{Err: ErrTimeout, Req: &Req{Pdu: pdu}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx, send)
+	}()
+
+	select {
+	case r := <-q.RespCh():
+		if r.Err != ErrTimeout {
+			t.Errorf("error [%v] not equals expected [%v]", r.Err, ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+	cancel()
+	<-done
+	_ = q.Close()
+
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Errorf("attempts [%v] not equals expected [3]", n)
+	}
+	if count := atomic.LoadInt32(&sink.count); count != 1 {
+		t.Errorf("dead letters [%v] not equals expected [1]", count)
+	}
+
+	q, err = OpenDurableQueue(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 0 {
+		t.Errorf("pending [%v] not equals expected [0]", q.Len())
+	}
+}
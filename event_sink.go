@@ -0,0 +1,148 @@
+package zkm
+
+import (
+	"sync/atomic"
+)
+
+type EventSink interface {
+	Put(evt Evt)
+}
+
+type EventSinkFunc func(evt Evt)
+
+func (f EventSinkFunc) Put(evt Evt) {
+	f(evt)
+}
+
+type ChanEventSink struct {
+	ch       chan Evt
+	blocking bool
+	dropped  uint64
+}
+
+func NewBlockingEventSink(size int) *ChanEventSink {
+	return &ChanEventSink{ch: make(chan Evt, size), blocking: true}
+}
+
+func NewDroppingEventSink(size int) *ChanEventSink {
+	return &ChanEventSink{ch: make(chan Evt, size)}
+}
+
+func (s *ChanEventSink) Put(evt Evt) {
+	if s.blocking {
+		s.ch <- evt
+		return
+	}
+
+	select {
+	case s.ch <- evt:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *ChanEventSink) C() <-chan Evt {
+	return s.ch
+}
+
+func (s *ChanEventSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+type SamplingEventSink struct {
+	next    EventSink
+	rate    uint64
+	counter uint64
+}
+
+func NewSamplingEventSink(next EventSink, rate uint64) *SamplingEventSink {
+	if rate < 1 {
+		rate = 1
+	}
+
+	return &SamplingEventSink{next: next, rate: rate}
+}
+
+func (s *SamplingEventSink) Put(evt Evt) {
+	switch e := evt.(type) {
+	case *ErrEvt, *StateChangedEvt, *OutRpsChangedEvt:
+		s.next.Put(evt)
+		return
+	case *LogEvt:
+		if e.severity >= Warning {
+			s.next.Put(evt)
+			return
+		}
+	}
+
+	if atomic.AddUint64(&s.counter, 1)%s.rate == 1%s.rate {
+		s.next.Put(evt)
+	}
+}
+
+type StructuredLogger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type LoggerEventSink struct {
+	logger StructuredLogger
+	fields []interface{}
+}
+
+func NewLoggerEventSink(logger StructuredLogger, fields ...interface{}) *LoggerEventSink {
+	return &LoggerEventSink{logger: logger, fields: fields}
+}
+
+// NewLoggerEventSinkFactory is meant for SessionConfig.EventSinkFactory, it adds session_id and remote_addr to fields
+func NewLoggerEventSinkFactory(logger StructuredLogger, fields ...interface{}) func(session *Session) EventSink {
+	return func(session *Session) EventSink {
+		sessionFields := make([]interface{}, 0, len(fields)+4)
+		sessionFields = append(sessionFields, fields...)
+		sessionFields = append(sessionFields, "session_id", session.Id())
+		if addr := session.RemoteAddr(); addr != nil {
+			sessionFields = append(sessionFields, "remote_addr", addr.String())
+		}
+
+		return NewLoggerEventSink(logger, sessionFields...)
+	}
+}
+
+func (s *LoggerEventSink) Put(evt Evt) {
+	switch e := evt.(type) {
+	case *LogEvt:
+		fields := s.fields
+		if e.id != 0 {
+			fields = s.with("command_id", e.id.String(), "seq", e.seq)
+		}
+
+		switch e.severity {
+		case Debug, ForceDebug:
+			s.logger.Debug(e.msg, fields...)
+		case Info:
+			s.logger.Info(e.msg, fields...)
+		case Warning:
+			s.logger.Warn(e.msg, fields...)
+		default:
+			s.logger.Error(e.msg, fields...)
+		}
+	case *ErrEvt:
+		s.logger.Error("session error", s.with("err", e.err)...)
+	case *PduReceivedEvt:
+		s.logger.Debug("received pdu", s.with("command_id", e.id.String(), "seq", e.seq, "status", e.status.String())...)
+	case *PduSentEvt:
+		s.logger.Debug("sent pdu", s.with("command_id", e.id.String(), "seq", e.seq, "status", e.status.String())...)
+	case *StateChangedEvt:
+		s.logger.Info("state changed", s.with("from", e.from.String(), "to", e.to.String())...)
+	default:
+		s.logger.Debug(evt.String(), s.fields...)
+	}
+}
+
+func (s *LoggerEventSink) with(args ...interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(s.fields)+len(args))
+	fields = append(fields, s.fields...)
+	return append(fields, args...)
+}
@@ -0,0 +1,128 @@
+package zkm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+)
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) log(level, msg string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args...) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args...) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args...) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args...) }
+
+func TestDroppingEventSink(t *testing.T) {
+	sink := NewDroppingEventSink(1)
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.LogSeverity = Debug
+	cfg.EventSink = sink
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for i := 0; i < 3; i++ {
+		go func() {
+			req, err := peer.Read()
+			if err != nil {
+				return
+			}
+			resp, _ := req.CreateResp(EsmeROk)
+			_ = peer.Write(resp)
+		}()
+		if _, err := session.Send(context.Background(), NewPdu(EnquireLink)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sink.Dropped() == 0 {
+		t.Error("events not dropped")
+	}
+	if len(sink.C()) != 1 {
+		t.Errorf("queued events [%v] not equals expected [1]", len(sink.C()))
+	}
+}
+
+func TestSamplingEventSink(t *testing.T) {
+	var passed []Evt
+	sink := NewSamplingEventSink(EventSinkFunc(func(evt Evt) {
+		passed = append(passed, evt)
+	}), 10)
+
+	for i := 0; i < 100; i++ {
+		sink.Put(&PduSentEvt{id: SubmitSm})
+	}
+	sink.Put(&ErrEvt{err: ErrTimeout})
+	sink.Put(&LogEvt{severity: Warning, msg: "warning"})
+	sink.Put(&LogEvt{severity: Debug, msg: "debug"})
+
+	if len(passed) != 13 {
+		t.Errorf("passed events [%v] not equals expected [13]", len(passed))
+	}
+}
+
+func TestLoggerEventSink(t *testing.T) {
+	logger := &testLogger{}
+	sink := NewLoggerEventSink(logger, "session_id", "s1", "remote_addr", "127.0.0.1:2775")
+
+	sink.Put(&LogEvt{severity: Warning, msg: "pdu not allowed"})
+	sink.Put(&PduSentEvt{id: SubmitSm, status: EsmeROk, seq: 7})
+	sink.Put(&ErrEvt{err: ErrTimeout})
+
+	expected := []string{
+		"WARN pdu not allowed [session_id s1 remote_addr 127.0.0.1:2775]",
+		"DEBUG sent pdu [session_id s1 remote_addr 127.0.0.1:2775 command_id SubmitSm seq 7 status ok]",
+		"ERROR session error [session_id s1 remote_addr 127.0.0.1:2775 err timeout wait for response]",
+	}
+	for i := range expected {
+		if logger.lines[i] != expected[i] {
+			t.Errorf("line [%v] not equals expected [%v]", logger.lines[i], expected[i])
+		}
+	}
+}
+
+func TestLoggerEventSinkFactory(t *testing.T) {
+	logger := &testLogger{}
+	cfg := NewDefaultSessionConfig()
+	cfg.LogSeverity = Debug
+	cfg.EventSinkFactory = NewLoggerEventSinkFactory(logger, "system_id", "esme1")
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	session := NewSessionWithConfig(NewSock(local), cfg, NewDefaultSpeedController(Robust))
+	other := NewSessionWithConfig(NewSock(local), cfg, NewDefaultSpeedController(Robust))
+	if session.Id() == other.Id() {
+		t.Errorf("session id [%v] equals other session id", session.Id())
+	}
+
+	pdu := NewPdu(SubmitSm)
+	pdu.SetSeq(7)
+	session.pduLogEvt(Debug, pdu, func() string { return "sent pdu" })
+	session.logEvt(Warning, func() string { return "pdu not allowed" })
+
+	fields := fmt.Sprintf("system_id esme1 session_id %v remote_addr %v", session.Id(), local.RemoteAddr())
+	expected := []string{
+		"DEBUG sent pdu [" + fields + " command_id SubmitSm seq 7]",
+		"WARN pdu not allowed [" + fields + "]",
+	}
+	if len(logger.lines) != len(expected) {
+		t.Fatalf("lines [%v] not equals expected [%v]", logger.lines, expected)
+	}
+	for i := range expected {
+		if logger.lines[i] != expected[i] {
+			t.Errorf("line [%v] not equals expected [%v]", logger.lines[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package zkm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Handler interface {
+	ServeSMPP(ctx context.Context, req *Pdu) *Pdu
+}
+
+type HandlerFunc func(ctx context.Context, req *Pdu) *Pdu
+
+func (f HandlerFunc) ServeSMPP(ctx context.Context, req *Pdu) *Pdu {
+	return f(ctx, req)
+}
+
+type Router struct {
+	handlers map[Id]Handler
+	mu       sync.RWMutex
+}
+
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[Id]Handler),
+	}
+}
+
+func (r *Router) Handle(id Id, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[id] = h
+}
+
+func (r *Router) HandleFunc(id Id, f func(ctx context.Context, req *Pdu) *Pdu) {
+	r.Handle(id, HandlerFunc(f))
+}
+
+func (r *Router) ServeSMPP(ctx context.Context, req *Pdu) *Pdu {
+	r.mu.RLock()
+	h, ok := r.handlers[req.Id()]
+	r.mu.RUnlock()
+
+	if ok {
+		return h.ServeSMPP(ctx, req)
+	}
+
+	resp, err := req.CreateResp(EsmeRInvCmdId)
+	if err != nil {
+		return nil
+	}
+
+	return resp
+}
+
+func (s *Session) handleInReqs(ctx context.Context) {
+	defer s.logEvt(Debug, func() string {
+		return fmt.Sprintf("goroutine handling incoming requests completed")
+	})
+
+	for {
+		select {
+		case req := <-s.handlerReqCh:
+			s.serve(ctx, req)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// serve answers req within HandlerTimeout, but the worker stays with the handler until it returns,
+// so handlers ignoring ctx can't pile up past HandlerWorkers.
+func (s *Session) serve(ctx context.Context, req *Pdu) {
+	hCtx, cancel := context.WithTimeout(ctx, s.handlerTimeout())
+	defer cancel()
+
+	result := make(chan *Pdu, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				s.logEvt(Error, func() string {
+					return fmt.Sprintf("handler panic on pdu [%v]: [%v]", req, r)
+				})
+				result <- nil
+			}
+		}()
+		result <- s.cfg.Handler.ServeSMPP(hCtx, req)
+	}()
+
+	select {
+	case resp := <-result:
+		s.respondHandled(ctx, req, resp)
+	case <-hCtx.Done():
+		s.logEvt(Warning, func() string {
+			return fmt.Sprintf("handler deadline exceeded for pdu [%v]", req)
+		})
+		s.respondHandled(ctx, req, nil)
+		<-result
+	}
+}
+
+func (s *Session) respondHandled(ctx context.Context, req *Pdu, resp *Pdu) {
+	if !req.id.hasResp() {
+		return
+	}
+
+	if resp == nil || resp.Id() != GenericNack && resp.Id() != req.Id()|GenericNack {
+		var err error
+		if resp, err = req.CreateResp(EsmeRSysErr); err != nil {
+			s.nack(ctx, req.seq, EsmeRSysErr)
+			return
+		}
+	}
+	resp.SetSeq(req.seq)
+
+	select {
+	case s.outRespCh <- resp:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Session) handlerTimeout() time.Duration {
+	if timeout := loadDuration(&s.cfg.HandlerTimeout); timeout > 0 {
+		return timeout
+	}
+
+	return defaultHandlerTimeout
+}
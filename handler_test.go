@@ -0,0 +1,178 @@
+package zkm
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSessionHandler(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(DeliverSm, func(ctx context.Context, req *Pdu) *Pdu {
+		switch addr, _ := req.GetMainAsString(DestinationAddr); addr {
+		case "panic":
+			panic("handler failed")
+		case "slow":
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			resp, _ := req.CreateResp(EsmeROk)
+			return resp
+		case "nil":
+			return nil
+		}
+		resp, _ := req.CreateResp(EsmeROk)
+		return resp
+	})
+
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	cfg.InWinLimit = 10
+	cfg.Handler = router
+	cfg.HandlerWorkers = 2
+	cfg.HandlerTimeout = 50 * time.Millisecond
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	tests := []struct {
+		id     Id
+		addr   string
+		status Status
+	}{
+		{DeliverSm, "1", EsmeROk},
+		{DeliverSm, "panic", EsmeRSysErr},
+		{DeliverSm, "slow", EsmeRSysErr},
+		{DeliverSm, "nil", EsmeRSysErr},
+		{DataSm, "1", EsmeRInvCmdId},
+	}
+
+	for i, test := range tests {
+		req := NewPdu(test.id)
+		req.SetSeq(uint32(i + 1))
+		_ = req.SetMain(DestinationAddr, test.addr)
+		if err := peer.Write(req); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id() != test.id|GenericNack || resp.Seq() != req.Seq() || resp.Status() != test.status {
+			t.Errorf("[%v] resp [%v] not equals expected [%v]", test.addr, resp, test.status)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if inWin := atomic.LoadInt32(&session.inWin); inWin != 0 {
+		t.Errorf("in window [%v] not equals expected [0]", inWin)
+	}
+}
+
+func TestSessionHandlerWorkersBounded(t *testing.T) {
+	var running, peak int32
+	release := make(chan struct{})
+	router := NewRouter()
+	router.HandleFunc(DeliverSm, func(ctx context.Context, req *Pdu) *Pdu {
+		n := atomic.AddInt32(&running, 1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	cfg.InWinLimit = 10
+	cfg.Handler = router
+	cfg.HandlerWorkers = 2
+	cfg.HandlerTimeout = 20 * time.Millisecond
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	defer close(release)
+
+	bindTestSession(t, session, peer)
+
+	go func() {
+		for i := 0; i < 4; i++ {
+			req := NewPdu(DeliverSm)
+			req.SetSeq(uint32(i + 1))
+			_ = peer.Write(req)
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		resp, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status() != EsmeRSysErr {
+			t.Errorf("resp status [%v] not equals expected [%v]", resp.Status(), EsmeRSysErr)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if p := atomic.LoadInt32(&peak); p != 2 {
+		t.Errorf("running handlers [%v] not equals expected [2]", p)
+	}
+}
+
+func TestSessionHandlerZeroConfig(t *testing.T) {
+	router := NewRouter()
+	router.HandleFunc(DeliverSm, func(ctx context.Context, req *Pdu) *Pdu {
+		time.Sleep(time.Millisecond)
+		resp, _ := req.CreateResp(EsmeROk)
+		return resp
+	})
+
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	cfg.Handler = router
+	cfg.HandlerWorkers = 0
+	cfg.HandlerTimeout = 0
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	for i := 0; i < 2; i++ {
+		req := NewPdu(DeliverSm)
+		req.SetSeq(uint32(i + 1))
+		if err := peer.Write(req); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id() != DeliverSmResp || resp.Status() != EsmeROk {
+			t.Errorf("resp [%v] not equals expected [%v]", resp, EsmeROk)
+		}
+	}
+
+	select {
+	case pdu := <-session.InReqCh():
+		t.Errorf("pdu [%v] handled by the handler is in InReqCh", pdu)
+	default:
+	}
+
+	if cfg.HandlerWorkers != 0 || cfg.HandlerTimeout != 0 {
+		t.Errorf("handler config [%v %v] changed by the session", cfg.HandlerWorkers, cfg.HandlerTimeout)
+	}
+}
@@ -0,0 +1,91 @@
+package zkm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type PduHandler func(ctx context.Context, pdu *Pdu) error
+
+type Interceptor func(next PduHandler) PduHandler
+
+type StatusError struct {
+	Status Status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rejected with status [%v]", e.Status)
+}
+
+func chainInterceptors(interceptors []Interceptor, handler PduHandler) PduHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		handler = interceptors[i](handler)
+	}
+
+	return handler
+}
+
+func interceptedStatus(err error) Status {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status
+	}
+
+	return EsmeRSysErr
+}
+
+// sockWriteError tells failures of the socket apart from errors returned by interceptors
+type sockWriteError struct {
+	err error
+}
+
+func (e *sockWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sockWriteError) Unwrap() error {
+	return e.err
+}
+
+func (s *Session) writePdu(_ context.Context, pdu *Pdu) error {
+	return s.sock.Write(pdu)
+}
+
+func (s *Session) writeReq(_ context.Context, pdu *Pdu) error {
+	if err := s.sock.Write(pdu); err != nil {
+		return &sockWriteError{err: err}
+	}
+
+	return nil
+}
+
+// outReqErr reports a failure of outReqHandler and returns the error to deliver with the response
+func (s *Session) outReqErr(pdu *Pdu, err error) error {
+	var writeErr *sockWriteError
+	if errors.As(err, &writeErr) {
+		if err == writeErr {
+			err = writeErr.err
+		}
+		s.logEvt(Error, func() string {
+			return fmt.Sprintf("can't write pdu [%v] to socket: [%v]", pdu, err)
+		})
+	} else {
+		s.logEvt(Warning, func() string {
+			return fmt.Sprintf("intercepted pdu [%v]: [%v]", pdu, err)
+		})
+	}
+	s.errEvt(err)
+
+	return err
+}
+
+func (s *Session) pushInReq(_ context.Context, pdu *Pdu) error {
+	if s.handlerReqCh != nil {
+		s.handlerReqCh <- pdu
+		return nil
+	}
+
+	s.inReqCh <- pdu
+	return nil
+}
@@ -0,0 +1,163 @@
+package zkm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInterceptors(t *testing.T) {
+	mu := sync.Mutex{}
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(next PduHandler) PduHandler {
+			return func(ctx context.Context, pdu *Pdu) error {
+				mu.Lock()
+				calls = append(calls, name+":"+pdu.Id().String())
+				mu.Unlock()
+				return next(ctx, pdu)
+			}
+		}
+	}
+
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	cfg.InWinLimit = 10
+	cfg.OutReqInterceptors = []Interceptor{record("out1"), record("out2"), func(next PduHandler) PduHandler {
+		return func(ctx context.Context, pdu *Pdu) error {
+			if pdu.Id() == SubmitSm {
+				_ = pdu.SetMain(SourceAddr, "normalized")
+			}
+			return next(ctx, pdu)
+		}
+	}}
+	cfg.InReqInterceptors = []Interceptor{func(next PduHandler) PduHandler {
+		return func(ctx context.Context, pdu *Pdu) error {
+			if addr, _ := pdu.GetMainAsString(DestinationAddr); addr == "0" {
+				return &StatusError{Status: EsmeRInvDstAdr}
+			}
+			return next(ctx, pdu)
+		}
+	}}
+	cfg.InRespInterceptors = []Interceptor{record("inResp")}
+	cfg.OutRespInterceptors = []Interceptor{record("outResp")}
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	submit, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := submit.GetMainAsString(SourceAddr); addr != "normalized" {
+		t.Errorf("source addr [%v] not equals expected [normalized]", addr)
+	}
+	resp, _ := submit.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	<-session.InRespCh()
+
+	for i, addr := range []string{"0", "1"} {
+		deliver := NewPdu(DeliverSm)
+		deliver.SetSeq(uint32(i + 1))
+		_ = deliver.SetMain(DestinationAddr, addr)
+		if err := peer.Write(deliver); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rejected, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejected.Id() != DeliverSmResp || rejected.Status() != EsmeRInvDstAdr {
+		t.Errorf("resp [%v] not equals expected [%v]", rejected, EsmeRInvDstAdr)
+	}
+
+	select {
+	case pdu := <-session.InReqCh():
+		if addr, _ := pdu.GetMainAsString(DestinationAddr); addr != "1" {
+			t.Errorf("destination addr [%v] not equals expected [1]", addr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deliver not received")
+	}
+
+	expected := []string{
+		"out1:BindTransceiver", "out2:BindTransceiver", "inResp:BindTransceiverResp",
+		"out1:SubmitSm", "out2:SubmitSm", "inResp:SubmitSmResp", "outResp:DeliverSmResp",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != len(expected) {
+		t.Fatalf("calls [%v] not equals expected [%v]", calls, expected)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Errorf("call [%v] not equals expected [%v]", calls[i], expected[i])
+		}
+	}
+}
+
+func TestInterceptorErrorsCompleteRequests(t *testing.T) {
+	errRejected := errors.New("rejected")
+	reject := func(id Id) Interceptor {
+		return func(next PduHandler) PduHandler {
+			return func(ctx context.Context, pdu *Pdu) error {
+				if pdu.Id() == id {
+					return errRejected
+				}
+				return next(ctx, pdu)
+			}
+		}
+	}
+
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.ReqTimeout = time.Minute
+	cfg.OutReqInterceptors = []Interceptor{reject(QuerySm)}
+	cfg.InRespInterceptors = []Interceptor{reject(SubmitSmResp)}
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	submit, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := submit.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	session.OutReqCh() <- &Req{Pdu: NewPdu(QuerySm)}
+
+	for _, id := range []Id{SubmitSm, QuerySm} {
+		select {
+		case r := <-session.InRespCh():
+			if r.Err != errRejected || r.Req.Pdu.Id() != id {
+				t.Errorf("resp [%v][%v] not equals expected [%v][%v]", r.Req.Pdu.Id(), r.Err, id, errRejected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("[%v] resp not received", id)
+		}
+	}
+
+	if outWin := atomic.LoadInt32(&session.outWin); outWin != 0 {
+		t.Errorf("out window [%v] not equals expected [0]", outWin)
+	}
+}
@@ -0,0 +1,174 @@
+package zkm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PduSpeedController limits pdus by their content on top of the session wide rate.
+// OutPdu blocks the caller until the pdu's limit allows it, so a single slow key stalls
+// every pdu behind it. Session uses TryOutPdu instead and parks the request until the
+// returned wait passes, keeping other keys flowing.
+type PduSpeedController interface {
+	SpeedController
+	OutPdu(ctx context.Context, pdu *Pdu) error
+	TryOutPdu(pdu *Pdu) (time.Duration, bool)
+}
+
+// keyedSweepInterval: buckets that refilled and stayed unused that long are dropped,
+// a dropped bucket is recreated full on the key's next pdu, so dropping it loses nothing.
+const keyedSweepInterval = time.Minute
+
+type KeyedSpeedController struct {
+	SpeedController
+	key       func(pdu *Pdu) string
+	limit     func(key string) int32
+	clock     Clock
+	limiters  map[string]*tokenBucket
+	overrides map[string]int32
+	swept     time.Time
+	mu        sync.Mutex
+}
+
+func NewKeyedSpeedController(base SpeedController, key func(pdu *Pdu) string, limit func(key string) int32) *KeyedSpeedController {
+	return NewKeyedSpeedControllerWithClock(base, key, limit, realClock{})
+}
+
+func NewKeyedSpeedControllerWithClock(base SpeedController, key func(pdu *Pdu) string, limit func(key string) int32, clock Clock) *KeyedSpeedController {
+	return &KeyedSpeedController{
+		SpeedController: base,
+		key:             key,
+		limit:           limit,
+		clock:           clock,
+		limiters:        make(map[string]*tokenBucket),
+		overrides:       make(map[string]int32),
+		swept:           clock.Now(),
+	}
+}
+
+func (c *KeyedSpeedController) OutPdu(ctx context.Context, pdu *Pdu) error {
+	if key := c.key(pdu); key != "" {
+		if err := c.outKey(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return c.SpeedController.Out(ctx)
+}
+
+func (c *KeyedSpeedController) TryOutPdu(pdu *Pdu) (time.Duration, bool) {
+	key := c.key(pdu)
+	if key == "" {
+		return 0, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.take(key)
+}
+
+func (c *KeyedSpeedController) outKey(ctx context.Context, key string) error {
+	for {
+		c.mu.Lock()
+		wait, ok := c.take(key)
+		c.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-c.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// take must be called with c.mu locked.
+func (c *KeyedSpeedController) take(key string) (time.Duration, bool) {
+	now := c.clock.Now()
+	if now.Sub(c.swept) >= keyedSweepInterval {
+		c.sweep(now)
+	}
+
+	b, ok := c.limiters[key]
+	if !ok {
+		limit, ok := c.overrides[key]
+		if !ok {
+			limit = c.limit(key)
+		}
+		if limit <= 0 {
+			return 0, true
+		}
+		b = newTokenBucket(limit, 1, now)
+		c.limiters[key] = b
+	}
+
+	return b.take(now)
+}
+
+// sweep must be called with c.mu locked.
+func (c *KeyedSpeedController) sweep(now time.Time) {
+	for key, b := range c.limiters {
+		if now.Sub(b.last) >= keyedSweepInterval && b.full(now) {
+			delete(c.limiters, key)
+		}
+	}
+	c.swept = now
+}
+
+// SetKeyRpsLimit overrides the limit function for key, limit <= 0 makes key unlimited.
+func (c *KeyedSpeedController) SetKeyRpsLimit(key string, limit int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.overrides[key] = limit
+	if b, ok := c.limiters[key]; ok {
+		if limit <= 0 {
+			delete(c.limiters, key)
+		} else {
+			b.setLimit(limit)
+		}
+	}
+}
+
+func (c *KeyedSpeedController) Feedback(status Status, err error) (int32, bool) {
+	if adaptive, ok := c.SpeedController.(AdaptiveSpeedController); ok {
+		return adaptive.Feedback(status, err)
+	}
+
+	return 0, false
+}
+
+func DestinationPrefixKey(prefixes ...string) func(pdu *Pdu) string {
+	return func(pdu *Pdu) string {
+		addr, err := pdu.GetMainAsString(DestinationAddr)
+		if err != nil {
+			return ""
+		}
+
+		key := ""
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(addr, prefix) && len(prefix) > len(key) {
+				key = prefix
+			}
+		}
+
+		return key
+	}
+}
+
+func MainParamKey(name Name) func(pdu *Pdu) string {
+	return func(pdu *Pdu) string {
+		value, err := pdu.GetMainAsString(name)
+		if err != nil {
+			return ""
+		}
+
+		return value
+	}
+}
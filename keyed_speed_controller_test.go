@@ -0,0 +1,179 @@
+package zkm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeyedSpeedController(t *testing.T) {
+	clock := newFakeClock()
+	base := NewTokenBucketSpeedControllerWithClock(1000, clock)
+	base.SetRpsLimit(1000, 1000)
+	c := NewKeyedSpeedControllerWithClock(base, DestinationPrefixKey("79", "7900"), func(key string) int32 {
+		if key == "7900" {
+			return 2
+		}
+		return 0
+	}, clock)
+
+	tests := []struct {
+		addr    string
+		count   int
+		elapsed time.Duration
+	}{
+		{"79001234567", 3, time.Second},
+		{"79011234567", 3, 0},
+		{"12345", 3, 0},
+		{"79001234567", 1, 500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(DestinationAddr, test.addr)
+
+		start := clock.Now()
+		for i := 0; i < test.count; i++ {
+			if err := c.OutPdu(context.Background(), pdu); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := clock.Now().Sub(start); elapsed != test.elapsed {
+			t.Errorf("[%v] elapsed [%v] not equals expected [%v]", test.addr, elapsed, test.elapsed)
+		}
+	}
+
+	pdu := NewPdu(SubmitSm)
+	_ = pdu.SetMain(ServiceType, "CMT")
+	if key := MainParamKey(ServiceType)(pdu); key != "CMT" {
+		t.Errorf("key [%v] not equals expected [CMT]", key)
+	}
+}
+
+func TestKeyedSpeedControllerOverridesAndFeedback(t *testing.T) {
+	clock := newFakeClock()
+	base := NewAIMDSpeedControllerWithClock(10, 10, 0.5, clock)
+	base.SetRpsLimit(1000, 100)
+	c := NewKeyedSpeedControllerWithClock(base, DestinationPrefixKey("79"), func(string) int32 { return 0 }, clock)
+
+	c.SetKeyRpsLimit("79", 1)
+
+	pdu := NewPdu(SubmitSm)
+	_ = pdu.SetMain(DestinationAddr, "79001234567")
+	if _, ok := c.TryOutPdu(pdu); !ok {
+		t.Error("first pdu of key not allowed")
+	}
+	if wait, ok := c.TryOutPdu(pdu); ok || wait != time.Second {
+		t.Errorf("second pdu of key [%v][%v] not equals expected [%v][false]", wait, ok, time.Second)
+	}
+
+	c.SetKeyRpsLimit("79", 0)
+	if _, ok := c.TryOutPdu(pdu); !ok {
+		t.Error("pdu of unlimited key not allowed")
+	}
+
+	if rate, changed := c.Feedback(EsmeRThrottled, nil); !changed || rate != 50 {
+		t.Errorf("feedback [%v][%v] not equals expected [50][true]", rate, changed)
+	}
+}
+
+func TestSessionKeyedSpeedControllerWithoutHeadOfLineBlocking(t *testing.T) {
+	local, remote := net.Pipe()
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 1000
+	cfg.OutWinLimit = 10
+	base := NewTokenBucketSpeedController(1000)
+	c := NewKeyedSpeedController(base, DestinationPrefixKey("79"), func(key string) int32 { return 1 })
+	session := NewSessionWithConfig(NewSock(local), cfg, c)
+	peer := NewSock(remote)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer remote.Close()
+		session.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		for range session.InEvtCh() {
+		}
+	}()
+
+	bindTestSession(t, session, peer)
+
+	read := make(chan string, 3)
+	go func() {
+		for {
+			pdu, err := peer.Read()
+			if err != nil {
+				return
+			}
+			addr, _ := pdu.GetMainAsString(DestinationAddr)
+			read <- addr
+		}
+	}()
+
+	for _, addr := range []string{"79001", "79002", "12345"} {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(DestinationAddr, addr)
+		session.OutReqCh() <- &Req{Pdu: pdu}
+	}
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		select {
+		case addr := <-read:
+			order = append(order, addr)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("pdus [%v] not sent", order)
+		}
+	}
+
+	if order[0] != "79001" || order[1] != "12345" || order[2] != "79002" {
+		t.Errorf("order [%v] not equals expected [79001 12345 79002]", order)
+	}
+}
+
+func TestKeyedSpeedControllerDropsIdleBuckets(t *testing.T) {
+	clock := newFakeClock()
+	base := NewTokenBucketSpeedControllerWithClock(1000, clock)
+	base.SetRpsLimit(1000, 1000)
+	c := NewKeyedSpeedControllerWithClock(base, MainParamKey(DestinationAddr), func(string) int32 { return 1 }, clock)
+
+	take := func(addr string) bool {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(DestinationAddr, addr)
+		_, ok := c.TryOutPdu(pdu)
+		return ok
+	}
+
+	for _, addr := range []string{"1", "2", "3"} {
+		take(addr)
+	}
+	clock.Advance(keyedSweepInterval - time.Second)
+	take("3")
+
+	tests := []struct {
+		advance time.Duration
+		count   int
+	}{
+		{0, 3},
+		{time.Second, 1},
+		{keyedSweepInterval, 0},
+	}
+
+	for _, test := range tests {
+		clock.Advance(test.advance)
+		if !take("4") {
+			t.Errorf("[%v] pdu of full bucket not allowed", test.advance)
+		}
+		if count := len(c.limiters); count != test.count+1 {
+			t.Errorf("[%v] buckets [%v] not equals expected [%v]", test.advance, count, test.count+1)
+		}
+	}
+}
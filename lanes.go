@@ -0,0 +1,239 @@
+package zkm
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+type LaneScheduling int
+
+const (
+	StrictPriority LaneScheduling = iota
+	WeightedFair
+)
+
+// LaneLowest selects lane 0 explicitly, Req.Lane 0 means the priority_flag lane when LaneByPriorityFlag is set.
+const LaneLowest int32 = -1
+
+type lanes struct {
+	chs            []chan *Req
+	weights        []int32
+	current        []int32
+	scheduling     LaneScheduling
+	byPriorityFlag bool
+	signal         chan struct{}
+	taken          chan struct{}
+}
+
+func newLanes(cfg *SessionConfig) *lanes {
+	if len(cfg.LaneWeights) == 0 {
+		return nil
+	}
+
+	l := &lanes{
+		chs:            make([]chan *Req, len(cfg.LaneWeights)),
+		weights:        append([]int32(nil), cfg.LaneWeights...),
+		current:        make([]int32, len(cfg.LaneWeights)),
+		scheduling:     cfg.LaneScheduling,
+		byPriorityFlag: cfg.LaneByPriorityFlag,
+		signal:         make(chan struct{}, 1),
+		taken:          make(chan struct{}, 1),
+	}
+
+	for i := range l.chs {
+		l.chs[i] = make(chan *Req, chanBuffSize)
+		if l.weights[i] < 1 {
+			l.weights[i] = 1
+		}
+	}
+
+	return l
+}
+
+func (l *lanes) index(r *Req) int {
+	lane := int(r.Lane)
+	if lane == 0 && l.byPriorityFlag {
+		if flag, err := r.Pdu.GetMainAsUint32(PriorityFlag); err == nil {
+			lane = int(flag)
+		}
+	}
+
+	if lane < 0 {
+		lane = 0
+	}
+	if lane >= len(l.chs) {
+		lane = len(l.chs) - 1
+	}
+
+	return lane
+}
+
+func (l *lanes) notify() {
+	select {
+	case l.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (l *lanes) push(ctx context.Context, r *Req) bool {
+	r.laneIdx = l.index(r)
+
+	select {
+	case l.chs[r.laneIdx] <- r:
+		l.notify()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *lanes) next() *Req {
+	r := l.pick()
+	if r != nil {
+		select {
+		case l.taken <- struct{}{}:
+		default:
+		}
+	}
+	return r
+}
+
+func (l *lanes) pick() *Req {
+	if l.scheduling == StrictPriority {
+		for i := len(l.chs) - 1; i >= 0; i-- {
+			select {
+			case r := <-l.chs[i]:
+				return r
+			default:
+			}
+		}
+		return nil
+	}
+
+	best, total := -1, int32(0)
+	for i, ch := range l.chs {
+		if len(ch) == 0 {
+			continue
+		}
+		l.current[i] += l.weights[i]
+		total += l.weights[i]
+		if best == -1 || l.current[i] > l.current[best] {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		return nil
+	}
+
+	l.current[best] -= total
+	return <-l.chs[best]
+}
+
+func (l *lanes) drain() []*Req {
+	var reqs []*Req
+	for _, ch := range l.chs {
+		for len(ch) > 0 {
+			reqs = append(reqs, <-ch)
+		}
+	}
+	return reqs
+}
+
+func (l *lanes) len() int {
+	n := 0
+	for _, ch := range l.chs {
+		n += len(ch)
+	}
+	return n
+}
+
+func (s *Session) handleIncomingLaneReqs(ctx context.Context) {
+	defer s.logEvt(Debug, func() string {
+		return fmt.Sprintf("goroutine handling incoming lane requests completed")
+	})
+
+	for {
+		// requests are accepted only while there is room, so callers still feel the window like without lanes
+		if int32(s.lanes.len()) >= s.laneQueueSize() {
+			select {
+			case <-s.lanes.taken:
+			case <-s.closing:
+				return
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		select {
+		case r := <-s.outReqCh:
+			if !s.lanes.push(ctx, r) {
+				s.deliverResp(&Resp{
+					Err: ErrClosed,
+					Req: r,
+				})
+				return
+			}
+		case <-s.closing:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Session) laneQueueSize() int32 {
+	if size := atomic.LoadInt32(&s.cfg.LaneQueueSize); size > 0 {
+		return size
+	}
+	if limit := atomic.LoadInt32(&s.cfg.OutWinLimit); limit > 0 {
+		return limit
+	}
+	return 1
+}
+
+func (s *Session) handleOutgoingLaneReqs(ctx context.Context) {
+	defer s.logEvt(Debug, func() string {
+		return fmt.Sprintf("goroutine handling outgoing requests completed")
+	})
+
+	var seq uint32
+
+	for {
+		select {
+		case s.outWinSema <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		r := s.nextLaneReq(ctx)
+		if r == nil {
+			return
+		}
+		s.handleOutgoingReq(r, &seq, ctx)
+	}
+}
+
+func (s *Session) nextLaneReq(ctx context.Context) *Req {
+	for {
+		select {
+		case r := <-s.internalReqCh:
+			return r
+		default:
+		}
+
+		if r := s.lanes.next(); r != nil {
+			return r
+		}
+
+		select {
+		case r := <-s.internalReqCh:
+			return r
+		case <-s.lanes.signal:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package zkm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLanesNext(t *testing.T) {
+	tests := []struct {
+		scheduling LaneScheduling
+		expected   []int
+	}{
+		{StrictPriority, []int{1, 1, 1, 1, 1, 1, 1, 1, 0, 0}},
+		{WeightedFair, []int{1, 0, 1, 1, 1, 0, 1, 1, 1, 0}},
+	}
+
+	for _, test := range tests {
+		cfg := NewDefaultSessionConfig()
+		cfg.LaneWeights = []int32{1, 3}
+		cfg.LaneScheduling = test.scheduling
+		cfg.LaneByPriorityFlag = true
+		l := newLanes(cfg)
+
+		for i := 0; i < 8; i++ {
+			for flag := 0; flag < 2; flag++ {
+				pdu := NewPdu(SubmitSm)
+				_ = pdu.SetMain(PriorityFlag, flag)
+				l.push(context.Background(), &Req{Pdu: pdu})
+			}
+		}
+
+		for i, expected := range test.expected {
+			if r := l.next(); r.laneIdx != expected {
+				t.Errorf("[%v] lane of req %v [%v] not equals expected [%v]", test.scheduling, i, r.laneIdx, expected)
+			}
+		}
+	}
+}
+
+func TestLanesIndex(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.LaneWeights = []int32{1, 1, 1}
+	cfg.LaneByPriorityFlag = true
+	l := newLanes(cfg)
+
+	tests := []struct {
+		lane     int32
+		flag     int
+		expected int
+	}{
+		{0, 2, 2},
+		{1, 2, 1},
+		{LaneLowest, 2, 0},
+		{5, 0, 2},
+	}
+
+	for _, test := range tests {
+		pdu := NewPdu(SubmitSm)
+		_ = pdu.SetMain(PriorityFlag, test.flag)
+		if idx := l.index(&Req{Pdu: pdu, Lane: test.lane}); idx != test.expected {
+			t.Errorf("[%v][%v] lane [%v] not equals expected [%v]", test.lane, test.flag, idx, test.expected)
+		}
+	}
+}
+
+func TestSessionLanes(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.LaneWeights = []int32{1, 1}
+	cfg.LaneQueueSize = 3
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	send := func(lane int32, info string) {
+		session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm), Lane: lane, TraceInfo: info}
+	}
+
+	send(0, "a")
+	first, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send(0, "b")
+	send(0, "c")
+	send(1, "h")
+	for deadline := time.Now().Add(time.Second); session.lanes.len() != 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued reqs [%v] not equals expected [3]", session.lanes.len())
+		}
+	}
+
+	pdu := first
+	for _, expected := range []string{"a", "h", "b", "c"} {
+		resp, _ := pdu.CreateResp(EsmeROk)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case r := <-session.InRespCh():
+			if r.Req.TraceInfo != expected {
+				t.Errorf("req [%v] not equals expected [%v]", r.Req.TraceInfo, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("resp not received")
+		}
+
+		if expected != "c" {
+			if pdu, err = peer.Read(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSessionLanesBackpressure(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.LaneWeights = []int32{1, 1}
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	first, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+
+	select {
+	case session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}:
+		t.Fatal("req accepted while window and lanes are full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resp, _ := first.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_, _ = peer.Read()
+	}()
+
+	select {
+	case session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}:
+	case <-time.After(time.Second):
+		t.Fatal("req not accepted after window opened")
+	}
+}
@@ -0,0 +1,293 @@
+package zkm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type pduKey struct {
+	id     Id
+	status Status
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type SessionMetrics struct {
+	buckets   []float64
+	sent      map[pduKey]uint64
+	received  map[pduKey]uint64
+	latencies map[Id]*histogram
+	timeouts  uint64
+	retries   uint64
+	throttles uint64
+	inWin     int32
+	outWin    int32
+	mu        sync.Mutex
+}
+
+func newSessionMetrics(buckets []float64) *SessionMetrics {
+	return &SessionMetrics{
+		buckets:   buckets,
+		sent:      make(map[pduKey]uint64),
+		received:  make(map[pduKey]uint64),
+		latencies: make(map[Id]*histogram),
+	}
+}
+
+func (m *SessionMetrics) pduSent(pdu *Pdu) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent[pduKey{id: pdu.id, status: pdu.status}]++
+}
+
+func (m *SessionMetrics) pduReceived(pdu *Pdu) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.received[pduKey{id: pdu.id, status: pdu.status}]++
+	if !pdu.IsReq() && pdu.status == EsmeRThrottled {
+		m.throttles++
+	}
+}
+
+func (m *SessionMetrics) resp(resp *Resp) {
+	if m == nil || resp.Req == nil {
+		return
+	}
+
+	if resp.Pdu == nil || resp.Req.Sent.IsZero() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.latencies[resp.Req.Pdu.id]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[resp.Req.Pdu.id] = h
+	}
+
+	latency := resp.Received.Sub(resp.Req.Sent).Seconds()
+	for i, le := range m.buckets {
+		if latency <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += latency
+}
+
+// timeout counts every request timeout, retried ones included
+func (m *SessionMetrics) timeout() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.timeouts++
+}
+
+func (m *SessionMetrics) retry() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retries++
+}
+
+func (m *SessionMetrics) inWinChanged(value int32) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inWin = value
+}
+
+func (m *SessionMetrics) outWinChanged(value int32) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.outWin = value
+}
+
+type Metrics struct {
+	buckets  []float64
+	sessions map[string]*SessionMetrics
+	mu       sync.Mutex
+}
+
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultLatencyBuckets)
+}
+
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	return &Metrics{
+		buckets:  append([]float64(nil), buckets...),
+		sessions: make(map[string]*SessionMetrics),
+	}
+}
+
+func (m *Metrics) Session(name string) *SessionMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sm, ok := m.sessions[name]
+	if !ok {
+		sm = newSessionMetrics(m.buckets)
+		m.sessions[name] = sm
+	}
+
+	return sm
+}
+
+func (m *Metrics) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, name)
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Expose(w)
+}
+
+func (m *Metrics) Expose(w io.Writer) error {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.sessions))
+	for name := range m.sessions {
+		names = append(names, name)
+	}
+	sessions := make(map[string]*SessionMetrics, len(m.sessions))
+	for name, sm := range m.sessions {
+		sessions[name] = sm
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+
+	writeHeader(bw, "zkm_pdus_sent_total", "counter", "Number of pdus written to the socket.")
+	for _, name := range names {
+		sm := sessions[name]
+		sm.mu.Lock()
+		writePduCounters(bw, "zkm_pdus_sent_total", name, sm.sent)
+		sm.mu.Unlock()
+	}
+
+	writeHeader(bw, "zkm_pdus_received_total", "counter", "Number of pdus read from the socket.")
+	for _, name := range names {
+		sm := sessions[name]
+		sm.mu.Lock()
+		writePduCounters(bw, "zkm_pdus_received_total", name, sm.received)
+		sm.mu.Unlock()
+	}
+
+	writeHeader(bw, "zkm_request_duration_seconds", "histogram", "Time from sending a request to receiving its response.")
+	for _, name := range names {
+		sm := sessions[name]
+		sm.mu.Lock()
+		ids := make([]Id, 0, len(sm.latencies))
+		for id := range sm.latencies {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			h := sm.latencies[id]
+			labels := fmt.Sprintf("session=\"%v\",command_id=\"0x%08X\"", escapeLabel(name), uint32(id))
+			for i, le := range sm.buckets {
+				fmt.Fprintf(bw, "zkm_request_duration_seconds_bucket{%v,le=\"%v\"} %v\n", labels, le, h.counts[i])
+			}
+			fmt.Fprintf(bw, "zkm_request_duration_seconds_bucket{%v,le=\"+Inf\"} %v\n", labels, h.count)
+			fmt.Fprintf(bw, "zkm_request_duration_seconds_sum{%v} %v\n", labels, h.sum)
+			fmt.Fprintf(bw, "zkm_request_duration_seconds_count{%v} %v\n", labels, h.count)
+		}
+		sm.mu.Unlock()
+	}
+
+	scalars := []struct {
+		name  string
+		typ   string
+		help  string
+		value func(sm *SessionMetrics) interface{}
+	}{
+		{"zkm_request_timeouts_total", "counter", "Number of requests without response within timeout.", func(sm *SessionMetrics) interface{} { return sm.timeouts }},
+		{"zkm_request_retries_total", "counter", "Number of resent requests.", func(sm *SessionMetrics) interface{} { return sm.retries }},
+		{"zkm_throttles_total", "counter", "Number of throttled responses received.", func(sm *SessionMetrics) interface{} { return sm.throttles }},
+		{"zkm_in_window", "gauge", "Incoming requests awaiting response.", func(sm *SessionMetrics) interface{} { return sm.inWin }},
+		{"zkm_out_window", "gauge", "Outgoing requests awaiting response.", func(sm *SessionMetrics) interface{} { return sm.outWin }},
+	}
+
+	for _, scalar := range scalars {
+		writeHeader(bw, scalar.name, scalar.typ, scalar.help)
+		for _, name := range names {
+			sm := sessions[name]
+			sm.mu.Lock()
+			fmt.Fprintf(bw, "%v{session=\"%v\"} %v\n", scalar.name, escapeLabel(name), scalar.value(sm))
+			sm.mu.Unlock()
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+func writePduCounters(w io.Writer, metric, session string, counters map[pduKey]uint64) {
+	keys := make([]pduKey, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%v{session=\"%v\",command_id=\"0x%08X\",status=\"0x%08X\"} %v\n",
+			metric, escapeLabel(session), uint32(k.id), uint32(k.status), counters[k])
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,129 @@
+package zkm
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.ThrottlePause = 0
+	cfg.ReqTimeout = 50 * time.Millisecond
+	cfg.Metrics = metrics.Session(`esme"1`)
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	for _, status := range []Status{EsmeRThrottled, EsmeROk} {
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := req.CreateResp(status)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-session.InRespCh()
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(QuerySm)}
+	if _, err := peer.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-session.InRespCh(); r.Err != ErrTimeout {
+		t.Fatalf("error [%v] not equals expected [%v]", r.Err, ErrTimeout)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	expected := []string{
+		`# TYPE zkm_pdus_sent_total counter`,
+		`zkm_pdus_sent_total{session="esme\"1",command_id="0x00000004",status="0x00000000"} 2`,
+		`zkm_pdus_received_total{session="esme\"1",command_id="0x80000004",status="0x00000000"} 1`,
+		`zkm_request_duration_seconds_bucket{session="esme\"1",command_id="0x00000004",le="+Inf"} 1`,
+		`zkm_request_duration_seconds_count{session="esme\"1",command_id="0x00000009"} 1`,
+		`zkm_request_timeouts_total{session="esme\"1"} 1`,
+		`zkm_request_retries_total{session="esme\"1"} 1`,
+		`zkm_throttles_total{session="esme\"1"} 1`,
+		`zkm_out_window{session="esme\"1"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("line [%v] not found in exposition:\n%s", line, body)
+		}
+	}
+}
+
+func TestWritePduCountersVendorStatuses(t *testing.T) {
+	var b strings.Builder
+	writePduCounters(&b, "zkm_pdus_received_total", "esme1", map[pduKey]uint64{
+		{id: SubmitSmResp, status: 0x400}: 1,
+		{id: SubmitSmResp, status: 0x401}: 2,
+	})
+
+	expected := `zkm_pdus_received_total{session="esme1",command_id="0x80000004",status="0x00000400"} 1
+zkm_pdus_received_total{session="esme1",command_id="0x80000004",status="0x00000401"} 2
+`
+	if b.String() != expected {
+		t.Errorf("exposition [%v] not equals expected [%v]", b.String(), expected)
+	}
+}
+
+func TestMetricsRetriedTimeouts(t *testing.T) {
+	metrics := NewMetrics()
+
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.ReqTimeout = 20 * time.Millisecond
+	cfg.RetryPolicy = NewFixedDelayRetryPolicy(3, 0)
+	cfg.Metrics = metrics.Session("esme1")
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	for i := 0; i < 3; i++ {
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			resp, _ := req.CreateResp(EsmeROk)
+			if err := peer.Write(resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	select {
+	case r := <-session.InRespCh():
+		if r.Err != nil {
+			t.Fatalf("error [%v] not equals expected [nil]", r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+
+	cfg.Metrics.mu.Lock()
+	defer cfg.Metrics.mu.Unlock()
+	if cfg.Metrics.timeouts != 2 {
+		t.Errorf("timeouts [%v] not equals expected [2]", cfg.Metrics.timeouts)
+	}
+}
@@ -0,0 +1,134 @@
+package zkm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+var ErrOutbindRejected = errors.New("outbind rejected")
+
+func NewOutbindClient(l net.Listener, cfg *ClientConfig) *Client {
+	c := NewClient(cfg)
+	c.l = l
+	return c
+}
+
+func (c *Client) acceptOutbind(ctx context.Context) (net.Conn, error) {
+	type acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	accepted := make(chan acceptResult, 1)
+	go func() {
+		conn, err := c.l.Accept()
+		accepted <- acceptResult{conn: conn, err: err}
+	}()
+
+	var conn net.Conn
+	select {
+	case r := <-accepted:
+		if r.err != nil {
+			return nil, r.err
+		}
+		conn = r.conn
+	case <-ctx.Done():
+		_ = c.l.Close()
+		if r := <-accepted; r.conn != nil {
+			_ = r.conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+
+	if c.cfg.TLSConfig != nil {
+		tlsConn := tls.Server(conn, c.cfg.TLSConfig)
+		if err := handshakeTLS(tlsConn, c.cfg.BindTimeout); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	if err := c.readOutbind(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (c *Client) readOutbind(conn net.Conn) error {
+	if err := conn.SetReadDeadline(time.Now().Add(c.cfg.BindTimeout)); err != nil {
+		return err
+	}
+
+	pdu, err := NewSock(conn).Read()
+	if err != nil {
+		return err
+	}
+
+	if pdu.Id() != Outbind {
+		return fmt.Errorf("%w: unexpected pdu [%v]", ErrOutbindRejected, pdu)
+	}
+
+	systemID, _ := pdu.GetMainAsString(SystemID)
+	password, _ := pdu.GetMainAsString(Password)
+
+	if systemID != c.cfg.OutbindSystemID || password != c.cfg.OutbindPassword {
+		return fmt.Errorf("%w: bad credentials for system id [%v]", ErrOutbindRejected, systemID)
+	}
+
+	c.logEvt(Info, func() string {
+		return fmt.Sprintf("outbind from [%v] accepted", conn.RemoteAddr())
+	})
+
+	return conn.SetReadDeadline(time.Time{})
+}
+
+func CreateOutbindPdu(systemID, password string) (*Pdu, error) {
+	pdu := NewPdu(Outbind)
+
+	if err := pdu.SetMain(SystemID, systemID); err != nil {
+		return nil, err
+	}
+	if err := pdu.SetMain(Password, password); err != nil {
+		return nil, err
+	}
+
+	return pdu, nil
+}
+
+func DialOutbind(ctx context.Context, network, addr, systemID, password string, tlsConfig *tls.Config, timeout time.Duration) (*Sock, error) {
+	pdu, err := CreateOutbindPdu(systemID, password)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, clientTLSConfig(tlsConfig, addr))
+		if err := handshakeTLS(tlsConn, timeout); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	pdu.SetSeq(1)
+	sock := NewSock(conn)
+	if err := sock.Write(pdu); err != nil {
+		_ = sock.Close()
+		return nil, err
+	}
+
+	return sock, nil
+}
@@ -0,0 +1,91 @@
+package zkm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOutbind(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewDefaultClientConfig("", "esme", "pass")
+	cfg.OutbindSystemID = "smsc"
+	cfg.OutbindPassword = "secret"
+	cfg.ReconnectMinDelay = 10 * time.Millisecond
+	cfg.ReconnectMaxDelay = 20 * time.Millisecond
+	client := NewOutbindClient(l, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+	go func() {
+		for range client.InEvtCh() {
+		}
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	rejected, err := DialOutbind(ctx, "tcp", l.Addr().String(), "smsc", "wrong", nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rejected.Read(); err == nil {
+		t.Error("outbind with bad credentials not rejected")
+	}
+
+	sock, err := DialOutbind(ctx, "tcp", l.Addr().String(), "smsc", "secret", nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionCfg := NewDefaultSessionConfig()
+	sessionCfg.InRpsLimit = 100
+	sessionCfg.OutRpsLimit = 100
+	session := NewSessionWithConfig(sock, sessionCfg, NewDefaultSpeedController(Robust))
+	go session.Run(ctx)
+	go func() {
+		for range session.InEvtCh() {
+		}
+	}()
+
+	select {
+	case bind := <-session.InReqCh():
+		if bind.Id() != BindReceiver {
+			t.Fatalf("unexpected bind [%v]", bind)
+		}
+		if systemID, _ := bind.GetMainAsString(SystemID); systemID != "esme" {
+			t.Errorf("system id [%v] not equals expected [esme]", systemID)
+		}
+		resp, _ := bind.CreateResp(EsmeROk)
+		session.OutRespCh() <- resp
+	case <-time.After(time.Second):
+		t.Fatal("bind not received")
+	}
+
+	for deadline := time.Now().Add(time.Second); session.State() != BoundRx; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("state [%v] not equals expected [%v]", session.State(), BoundRx)
+		}
+	}
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(DeliverSm)}
+
+	select {
+	case pdu := <-client.InReqCh():
+		if pdu.Id() != DeliverSm {
+			t.Errorf("unexpected pdu [%v]", pdu)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deliver not received")
+	}
+}
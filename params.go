@@ -3,13 +3,17 @@ package zkm
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
 )
 
 var ParamNotFound = fmt.Errorf("not found")
 var ParamBadType = fmt.Errorf("bad type")
 
+var errInvalidMsgLen = errors.New("invalid short message length")
+
 type Name string
 
 const (
@@ -243,6 +247,9 @@ func (ps *mandatoryParams) serialize() []byte {
 func (ps *mandatoryParams) deserialize(buff *bytes.Buffer) error {
 	for _, n := range ps.names {
 		if err := ps.params[n].value().deserialize(buff); err != nil {
+			if n == ShortMessage {
+				return fmt.Errorf("%w: %v", errInvalidMsgLen, err)
+			}
 			return err
 		}
 
@@ -345,9 +352,16 @@ func (ps *optionalParams) len() uint32 {
 }
 
 func (ps *optionalParams) serialize() []byte {
+	tags := make([]Tag, 0, len(ps.params))
+	for tag := range ps.params {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
 	buff := bytes.Buffer{}
 	b := make([]byte, 2)
-	for _, p := range ps.params {
+	for _, tag := range tags {
+		p := ps.params[tag]
 		binary.BigEndian.PutUint16(b, uint16(p.t))
 		buff.Write(b)
 		binary.BigEndian.PutUint16(b, uint16(p.value().len()))
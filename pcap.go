@@ -0,0 +1,309 @@
+package zkm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagic         = 0xa1b2c3d4
+	pcapLinkTypeRaw   = 101
+	pcapSnapLen       = 65535
+	pcapHeaderSize    = 24
+	pcapRecHeaderSize = 16
+	pcapMaxPayload    = 65535 - 60 - 20
+)
+
+type PcapConfig struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+func NewDefaultPcapConfig(path string) *PcapConfig {
+	return &PcapConfig{
+		Path:       path,
+		MaxSize:    100 * 1024 * 1024,
+		MaxBackups: 5,
+	}
+}
+
+type PcapWriter struct {
+	cfg  *PcapConfig
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+func NewPcapWriter(cfg *PcapConfig) (*PcapWriter, error) {
+	w := &PcapWriter{cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *PcapWriter) open() error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, pcapHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+
+	w.f = f
+	w.size = pcapHeaderSize
+	return nil
+}
+
+func (w *PcapWriter) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+
+	if w.cfg.MaxBackups > 0 {
+		for i := w.cfg.MaxBackups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%v.%v", w.cfg.Path, i), fmt.Sprintf("%v.%v", w.cfg.Path, i+1))
+		}
+		if err := os.Rename(w.cfg.Path, w.cfg.Path+".1"); err != nil {
+			return err
+		}
+	}
+
+	return w.open()
+}
+
+func (w *PcapWriter) writePacket(ts time.Time, packet []byte) error {
+	if w.f == nil {
+		return os.ErrClosed
+	}
+
+	if w.cfg.MaxSize > 0 && w.size+int64(pcapRecHeaderSize+len(packet)) > w.cfg.MaxSize && w.size > pcapHeaderSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, pcapRecHeaderSize+len(packet))
+	binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	copy(record[pcapRecHeaderSize:], packet)
+
+	n, err := w.f.Write(record)
+	w.size += int64(n)
+	return err
+}
+
+func (w *PcapWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+type pcapFlow struct {
+	w         *PcapWriter
+	local     *net.TCPAddr
+	remote    *net.TCPAddr
+	localSeq  uint32
+	remoteSeq uint32
+	pending   []*pcapSlot
+}
+
+type pcapSlot struct {
+	ts       time.Time
+	outbound bool
+	payload  []byte
+	ready    bool
+}
+
+func newPcapFlow(w *PcapWriter, conn net.Conn) *pcapFlow {
+	return &pcapFlow{
+		w:         w,
+		local:     pcapAddr(conn.LocalAddr(), net.IPv4(127, 0, 0, 1), 1),
+		remote:    pcapAddr(conn.RemoteAddr(), net.IPv4(127, 0, 0, 2), 2775),
+		localSeq:  1,
+		remoteSeq: 1,
+	}
+}
+
+func pcapAddr(addr net.Addr, ip net.IP, port int) *net.TCPAddr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP != nil {
+		return tcpAddr
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+func (f *pcapFlow) write(outbound bool, payload []byte) error {
+	return f.commit(f.reserve(outbound, payload))
+}
+
+// reserve keeps the packet's place, packets behind it wait until it's committed or dropped
+func (f *pcapFlow) reserve(outbound bool, payload []byte) *pcapSlot {
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+
+	slot := &pcapSlot{ts: time.Now(), outbound: outbound, payload: payload}
+	f.pending = append(f.pending, slot)
+	return slot
+}
+
+func (f *pcapFlow) commit(slot *pcapSlot) error {
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+
+	slot.ready = true
+	return f.flush()
+}
+
+func (f *pcapFlow) drop(slot *pcapSlot) error {
+	f.w.mu.Lock()
+	defer f.w.mu.Unlock()
+
+	for i, pending := range f.pending {
+		if pending == slot {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			break
+		}
+	}
+
+	return f.flush()
+}
+
+func (f *pcapFlow) flush() error {
+	for len(f.pending) > 0 && f.pending[0].ready {
+		slot := f.pending[0]
+		f.pending[0] = nil
+		f.pending = f.pending[1:]
+
+		if err := f.writePackets(slot.ts, slot.outbound, slot.payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *pcapFlow) writePackets(now time.Time, outbound bool, payload []byte) error {
+	for len(payload) > 0 {
+		chunk := payload
+		if len(chunk) > pcapMaxPayload {
+			chunk = chunk[:pcapMaxPayload]
+		}
+		payload = payload[len(chunk):]
+
+		var packet []byte
+		if outbound {
+			packet = tcpPacket(f.local, f.remote, f.localSeq, f.remoteSeq, chunk)
+			f.localSeq += uint32(len(chunk))
+		} else {
+			packet = tcpPacket(f.remote, f.local, f.remoteSeq, f.localSeq, chunk)
+			f.remoteSeq += uint32(len(chunk))
+		}
+
+		if err := f.w.writePacket(now, packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tcpPacket(src, dst *net.TCPAddr, seq, ack uint32, payload []byte) []byte {
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+
+	var pseudo []byte
+	var ip []byte
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:], src4)
+		copy(pseudo[4:], dst4)
+		pseudo[9] = 6
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(tcp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000)
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, nil))
+	} else {
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:], src.IP.To16())
+		copy(pseudo[16:], dst.IP.To16())
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(tcp)))
+		pseudo[39] = 6
+
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+	}
+
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	return append(ip, tcp...)
+}
+
+func checksum(parts ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var prev byte
+	for _, part := range parts {
+		for _, b := range part {
+			if odd {
+				sum += uint32(prev)<<8 | uint32(b)
+			} else {
+				prev = b
+			}
+			odd = !odd
+		}
+	}
+	if odd {
+		sum += uint32(prev) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,165 @@
+package zkm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readPcap(t *testing.T, path string) [][]byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if binary.LittleEndian.Uint32(data) != pcapMagic || binary.LittleEndian.Uint32(data[20:]) != pcapLinkTypeRaw {
+		t.Fatalf("bad pcap header [%X]", data[:pcapHeaderSize])
+	}
+
+	var packets [][]byte
+	for data = data[pcapHeaderSize:]; len(data) > 0; {
+		l := binary.LittleEndian.Uint32(data[8:])
+		packets = append(packets, data[pcapRecHeaderSize:pcapRecHeaderSize+l])
+		data = data[pcapRecHeaderSize+l:]
+	}
+
+	return packets
+}
+
+func TestPcapCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	remote, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	cfg := NewDefaultPcapConfig(filepath.Join(dir, "smpp.pcap"))
+	cfg.MaxSize = 150
+	w, err := NewPcapWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock, peer := NewSock(conn), NewSock(remote)
+	sock.SetCapture(w)
+
+	req := NewPdu(EnquireLink)
+	req.SetSeq(1)
+	if err := sock.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peer.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _ := req.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sock.Read(); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	local, remoteAddr := conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr)
+	tests := []struct {
+		path    string
+		src     *net.TCPAddr
+		dst     *net.TCPAddr
+		payload []byte
+	}{
+		{cfg.Path + ".1", local, remoteAddr, req.Serialize()},
+		{cfg.Path, remoteAddr, local, resp.Serialize()},
+	}
+
+	for _, test := range tests {
+		packets := readPcap(t, test.path)
+		if len(packets) != 1 {
+			t.Fatalf("[%v] packets [%v] not equals expected [1]", test.path, len(packets))
+		}
+
+		packet := packets[0]
+		if checksum(packet[:20]) != 0 {
+			t.Errorf("[%v] bad ip checksum", test.path)
+		}
+		if !net.IP(packet[12:16]).Equal(test.src.IP) || !net.IP(packet[16:20]).Equal(test.dst.IP) {
+			t.Errorf("[%v] addresses [%v -> %v] not equals expected [%v -> %v]",
+				test.path, net.IP(packet[12:16]), net.IP(packet[16:20]), test.src.IP, test.dst.IP)
+		}
+		if src, dst := int(binary.BigEndian.Uint16(packet[20:])), int(binary.BigEndian.Uint16(packet[22:])); src != test.src.Port || dst != test.dst.Port {
+			t.Errorf("[%v] ports [%v -> %v] not equals expected [%v -> %v]", test.path, src, dst, test.src.Port, test.dst.Port)
+		}
+		if !bytes.Equal(packet[40:], test.payload) {
+			t.Errorf("[%v] payload [%X] not equals expected [%X]", test.path, packet[40:], test.payload)
+		}
+	}
+}
+
+func TestPcapCaptureOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewDefaultPcapConfig(filepath.Join(dir, "smpp.pcap"))
+	w, err := NewPcapWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote := net.Pipe()
+	flow := newPcapFlow(w, local)
+
+	req, failed := NewPdu(EnquireLink), NewPdu(SubmitSm)
+	resp, _ := req.CreateResp(EsmeROk)
+	sent := flow.reserve(true, req.Serialize())
+	lost := flow.reserve(true, failed.Serialize())
+	if err := flow.write(false, resp.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	_ = flow.commit(sent)
+	_ = flow.drop(lost)
+
+	_ = remote.Close()
+	sock := NewSock(local)
+	sock.SetCapture(w)
+	if err := sock.Write(failed); err == nil {
+		t.Fatal("write to closed pipe not failed")
+	}
+	_ = w.Close()
+
+	packets := readPcap(t, cfg.Path)
+	if len(packets) != 2 {
+		t.Fatalf("packets [%v] not equals expected [2]", len(packets))
+	}
+	if !bytes.Equal(packets[0][40:], req.Serialize()) || !bytes.Equal(packets[1][40:], resp.Serialize()) {
+		t.Errorf("packets [%X] not equals expected [%X %X]", packets, req.Serialize(), resp.Serialize())
+	}
+	reqSeq, respAck := binary.BigEndian.Uint32(packets[0][24:]), binary.BigEndian.Uint32(packets[1][28:])
+	if respAck != reqSeq+uint32(len(req.Serialize())) {
+		t.Errorf("resp ack [%v] not equals expected [%v]", respAck, reqSeq+uint32(len(req.Serialize())))
+	}
+}
@@ -11,6 +11,9 @@ type Status uint32
 
 const pduHeaderPartSize = 4
 
+// MaxPduLen bounds command_length, longer pdus are rejected without reading them
+const MaxPduLen = 128 * 1024
+
 const (
 	GenericNack         Id = 0x80000000
 	BindReceiver        Id = 0x00000001
@@ -349,6 +352,14 @@ func (pdu *Pdu) Len() uint32 {
 	return 4*pduHeaderPartSize + pdu.mandatoryParams.len() + pdu.optionalParams.len()
 }
 
+func (id Id) hasResp() bool {
+	return id != Outbind && id != AlertNotification
+}
+
+func (id Id) isKnown() bool {
+	return id.String() != "Unknown"
+}
+
 func (id Id) String() string {
 	switch id {
 	case GenericNack:
@@ -0,0 +1,298 @@
+package zkm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type PoolMember interface {
+	InRespCh() <-chan *Resp
+	InReqCh() <-chan *Pdu
+	InEvtCh() <-chan Evt
+	OutReqCh() chan<- *Req
+	OutRespCh() chan<- *Pdu
+}
+
+type PoolReq struct {
+	Pdu       *Pdu
+	OutRespCh chan<- *Pdu
+}
+
+type poolMember struct {
+	m    PoolMember
+	done chan struct{}
+}
+
+func (m *poolMember) session() *Session {
+	switch m := m.m.(type) {
+	case *Session:
+		return m
+	case *Client:
+		return m.Session()
+	}
+	return nil
+}
+
+type Pool struct {
+	members    []*poolMember
+	outReqCh   chan *Req
+	failoverCh chan *Req
+	inRespCh   chan *Resp
+	inReqCh    chan *PoolReq
+	evtCh      chan Evt
+	changed    chan struct{}
+	stop       chan struct{}
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+func NewPool() *Pool {
+	return &Pool{
+		outReqCh:   make(chan *Req),
+		failoverCh: make(chan *Req, chanBuffSize),
+		inRespCh:   make(chan *Resp, chanBuffSize),
+		inReqCh:    make(chan *PoolReq, chanBuffSize),
+		evtCh:      make(chan Evt, chanBuffSize),
+		changed:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+func (p *Pool) OutReqCh() chan<- *Req {
+	return p.outReqCh
+}
+
+func (p *Pool) InRespCh() <-chan *Resp {
+	return p.inRespCh
+}
+
+func (p *Pool) InReqCh() <-chan *PoolReq {
+	return p.inReqCh
+}
+
+func (p *Pool) InEvtCh() <-chan Evt {
+	return p.evtCh
+}
+
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.members)
+}
+
+func (p *Pool) Add(m PoolMember) {
+	member := &poolMember{m: m, done: make(chan struct{})}
+
+	p.mu.Lock()
+	p.members = append(p.members, member)
+	p.mu.Unlock()
+	p.notify()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.forward(member)
+	}()
+}
+
+func (p *Pool) Run(ctx context.Context) {
+	defer func() {
+		close(p.stop)
+		p.wg.Wait()
+
+		for len(p.failoverCh) > 0 {
+			p.inRespCh <- &Resp{
+				Err: ErrClosed,
+				Req: <-p.failoverCh,
+			}
+		}
+
+		close(p.evtCh)
+		close(p.inRespCh)
+		close(p.inReqCh)
+	}()
+
+	for {
+		var r *Req
+		select {
+		case r = <-p.failoverCh:
+		default:
+			select {
+			case r = <-p.failoverCh:
+			case r = <-p.outReqCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := p.route(ctx, r); err != nil {
+			p.inRespCh <- &Resp{
+				Err: err,
+				Req: r,
+			}
+			if err == ErrClosed {
+				return
+			}
+		}
+	}
+}
+
+func (p *Pool) route(ctx context.Context, r *Req) error {
+	for {
+		m, waitable := p.pick(r.Pdu)
+		if m == nil {
+			if !waitable {
+				return ErrInvalidBindState
+			}
+
+			select {
+			case <-p.changed:
+			case <-time.After(supervisionInterval):
+			case <-ctx.Done():
+				return ErrClosed
+			}
+			continue
+		}
+
+		// a busy member is not waited for, another one may free up meanwhile
+		r.pooled = true
+		repick := time.NewTimer(supervisionInterval)
+		select {
+		case m.m.OutReqCh() <- r:
+			repick.Stop()
+			return nil
+		case <-m.done:
+		case <-p.changed:
+		case <-repick.C:
+		case <-ctx.Done():
+			repick.Stop()
+			return ErrClosed
+		}
+		repick.Stop()
+	}
+}
+
+// pick returns the least loaded member able to send pdu. Without one, waitable tells whether
+// some member may still become able: it is unbound or reconnecting, or the pool is empty.
+func (p *Pool) pick(pdu *Pdu) (*poolMember, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *poolMember
+	var bestLoad float64
+	var bestInWin int32
+	waitable := len(p.members) == 0
+	for _, m := range p.members {
+		s := m.session()
+		if s == nil || !s.State().IsBound() {
+			waitable = true
+			continue
+		}
+		if s.checkOutgoingReq(pdu) != nil {
+			continue
+		}
+
+		load := float64(atomic.LoadInt32(&s.outWin))
+		if s.lanes != nil {
+			load += float64(s.lanes.len())
+		}
+		if limit := atomic.LoadInt32(&s.cfg.OutWinLimit); limit > 0 {
+			load /= float64(limit)
+		}
+		inWin := atomic.LoadInt32(&s.inWin)
+
+		if best == nil || load < bestLoad || load == bestLoad && inWin < bestInWin {
+			best, bestLoad, bestInWin = m, load, inWin
+		}
+	}
+
+	return best, waitable
+}
+
+func (p *Pool) forward(m *poolMember) {
+	defer p.remove(m)
+
+	respCh, reqCh, evtCh := m.m.InRespCh(), m.m.InReqCh(), m.m.InEvtCh()
+	for respCh != nil || reqCh != nil || evtCh != nil {
+		select {
+		case r, ok := <-respCh:
+			if !ok {
+				respCh = nil
+				p.remove(m)
+				continue
+			}
+			p.onResp(r)
+		case pdu, ok := <-reqCh:
+			if !ok {
+				reqCh = nil
+				continue
+			}
+			select {
+			case p.inReqCh <- &PoolReq{Pdu: pdu, OutRespCh: m.m.OutRespCh()}:
+			case <-p.stop:
+				return
+			}
+		case evt, ok := <-evtCh:
+			if !ok {
+				evtCh = nil
+				continue
+			}
+			if _, ok := evt.(*StateChangedEvt); ok {
+				p.notify()
+			}
+			select {
+			case p.evtCh <- evt:
+			case <-p.stop:
+				return
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// failoverable tells whether the pool resends the request to another member
+func failoverable(r *Resp) bool {
+	return r.Req != nil && r.Req.pooled && r.Req.Sent.IsZero() &&
+		(errors.Is(r.Err, ErrClosed) || errors.Is(r.Err, ErrInvalidBindState))
+}
+
+func (p *Pool) onResp(r *Resp) {
+	if failoverable(r) {
+		select {
+		case p.failoverCh <- r.Req:
+			return
+		default:
+		}
+	}
+
+	select {
+	case p.inRespCh <- r:
+	case <-p.stop:
+	}
+}
+
+func (p *Pool) remove(m *poolMember) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, member := range p.members {
+		if member == m {
+			p.members = append(p.members[:i], p.members[i+1:]...)
+			close(m.done)
+			p.notify()
+			return
+		}
+	}
+}
+
+func (p *Pool) notify() {
+	select {
+	case p.changed <- struct{}{}:
+	default:
+	}
+}
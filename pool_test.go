@@ -0,0 +1,278 @@
+package zkm
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	var sessions []*Session
+	var peers []*Sock
+	var cancels []func()
+	var dones []<-chan struct{}
+
+	pool := NewPool()
+	for i := 0; i < 2; i++ {
+		cfg := NewDefaultSessionConfig()
+		cfg.InRpsLimit = 100
+		cfg.OutRpsLimit = 100
+		cfg.OutWinLimit = 2
+		cfg.InWinLimit = 2
+		session, peer, cancel, done := newTestSession(cfg)
+		bindTestSession(t, session, peer)
+		pool.Add(session)
+		sessions = append(sessions, session)
+		peers = append(peers, peer)
+		cancels = append(cancels, cancel)
+		dones = append(dones, done)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx)
+	}()
+	defer func() {
+		for i := range cancels {
+			cancels[i]()
+			<-dones[i]
+		}
+		cancel()
+		<-done
+	}()
+
+	read := func(peer *Sock, id Id) *Pdu {
+		pdu, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.Id() != id {
+			t.Fatalf("pdu [%v] not equals expected [%v]", pdu.Id(), id)
+		}
+		return pdu
+	}
+
+	var submits []*Pdu
+	for i, peer := range peers {
+		pool.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+		submits = append(submits, read(peer, SubmitSm))
+		for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&sessions[i].outWin) != 1; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("out window not changed")
+			}
+		}
+	}
+
+	deliver := NewPdu(DeliverSm)
+	deliver.SetSeq(1)
+	if err := peers[1].Write(deliver); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-pool.InReqCh():
+		resp, _ := r.Pdu.CreateResp(EsmeROk)
+		r.OutRespCh <- resp
+	case <-time.After(time.Second):
+		t.Fatal("deliver not received")
+	}
+	read(peers[1], DeliverSmResp)
+
+	for i, peer := range peers {
+		resp, _ := submits[i].CreateResp(EsmeROk)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-pool.InRespCh():
+			if r.Err != nil || r.Pdu.Id() != SubmitSmResp {
+				t.Errorf("unexpected resp [%v][%v]", r.Pdu, r.Err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("resp not received")
+		}
+	}
+
+	cancels[0]()
+	<-dones[0]
+	for deadline := time.Now().Add(time.Second); pool.Len() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool len [%v] not equals expected [1]", pool.Len())
+		}
+	}
+
+	pool.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	read(peers[1], SubmitSm)
+}
+
+func TestPoolRejectsPduNoMemberCanSend(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	session, peer, cancelSession, sessionDone := newTestSession(cfg)
+	defer func() {
+		cancelSession()
+		<-sessionDone
+	}()
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(BindReceiver)}
+	bind, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bindResp, _ := bind.CreateResp(EsmeROk)
+	if err := peer.Write(bindResp); err != nil {
+		t.Fatal(err)
+	}
+	<-session.InRespCh()
+
+	pool := NewPool()
+	pool.Add(session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	pool.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	select {
+	case r := <-pool.InRespCh():
+		if r.Err != ErrInvalidBindState {
+			t.Errorf("error [%v] not equals expected [%v]", r.Err, ErrInvalidBindState)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("req not rejected")
+	}
+
+	select {
+	case pool.OutReqCh() <- &Req{Pdu: NewPdu(EnquireLink)}:
+	case <-time.After(time.Second):
+		t.Fatal("pool stopped serving requests")
+	}
+	if pdu, err := peer.Read(); err != nil || pdu.Id() != EnquireLink {
+		t.Errorf("pdu [%v][%v] not equals expected [%v]", pdu, err, EnquireLink)
+	}
+}
+
+type countingDeadLetterSink struct {
+	count int32
+}
+
+func (s *countingDeadLetterSink) Put(dl *DeadLetter) error {
+	atomic.AddInt32(&s.count, 1)
+	return nil
+}
+
+func TestPoolFailoverWithoutDeadLetter(t *testing.T) {
+	sink := &countingDeadLetterSink{}
+	newMember := func(outRpsLimit int32) (*Session, *Sock, func(), <-chan struct{}) {
+		cfg := NewDefaultSessionConfig()
+		cfg.InRpsLimit = 100
+		cfg.OutRpsLimit = outRpsLimit
+		cfg.DeadLetterSink = sink
+		session, peer, cancel, done := newTestSession(cfg)
+		bindTestSession(t, session, peer)
+		return session, peer, cancel, done
+	}
+
+	slow, _, cancelSlow, slowDone := newMember(1)
+	defer func() {
+		cancelSlow()
+		<-slowDone
+	}()
+	fast, fastPeer, cancelFast, fastDone := newMember(100)
+	defer func() {
+		cancelFast()
+		<-fastDone
+	}()
+
+	pool := NewPool()
+	pool.Add(slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	pool.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	time.Sleep(50 * time.Millisecond)
+	pool.Add(fast)
+	cancelSlow()
+	<-slowDone
+
+	if pdu, err := fastPeer.Read(); err != nil || pdu.Id() != SubmitSm {
+		t.Fatalf("pdu [%v][%v] not equals expected [%v]", pdu, err, SubmitSm)
+	}
+	if count := atomic.LoadInt32(&sink.count); count != 0 {
+		t.Errorf("dead letters [%v] not equals expected [0]", count)
+	}
+}
+
+func TestPoolRepicksBusyMember(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	cfg.OutWinLimit = 1
+	busy, busyPeer, cancelBusy, busyDone := newTestSession(cfg)
+	defer func() {
+		cancelBusy()
+		<-busyDone
+	}()
+	bindTestSession(t, busy, busyPeer)
+
+	busy.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	if _, err := busyPeer.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewPool()
+	pool.Add(busy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	pool.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+
+	free, freePeer, cancelFree, freeDone := newTestSession(cfg)
+	defer func() {
+		cancelFree()
+		<-freeDone
+	}()
+	bindTestSession(t, free, freePeer)
+	pool.Add(free)
+
+	read := make(chan *Pdu, 1)
+	go func() {
+		pdu, _ := freePeer.Read()
+		read <- pdu
+	}()
+	select {
+	case pdu := <-read:
+		if pdu == nil || pdu.Id() != SubmitSm {
+			t.Errorf("pdu [%v] not equals expected [%v]", pdu, SubmitSm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("req stuck behind busy member")
+	}
+}
@@ -0,0 +1,310 @@
+package zkm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	recordingMagic      = "ZKMR"
+	recordingHeaderSize = 13
+)
+
+type Direction byte
+
+const (
+	Inbound Direction = iota + 1
+	Outbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	default:
+		return fmt.Sprintf("unknown direction [%d]", byte(d))
+	}
+}
+
+type RecordedPdu struct {
+	Time      time.Time
+	Direction Direction
+	Raw       []byte
+}
+
+func (r *RecordedPdu) Pdu() (*Pdu, error) {
+	pdu := NewEmptyPdu()
+	if err := pdu.Deserialize(append([]byte(nil), r.Raw...)); err != nil {
+		return nil, err
+	}
+
+	return pdu, nil
+}
+
+type Recorder struct {
+	f       *os.File
+	pending []*recordSlot
+	mu      sync.Mutex
+}
+
+type recordSlot struct {
+	b     []byte
+	ready bool
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Write([]byte(recordingMagic)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &Recorder{f: f}, nil
+}
+
+func (r *Recorder) Record(direction Direction, pdu *Pdu) error {
+	return r.record(time.Now(), direction, pdu.Serialize())
+}
+
+func (r *Recorder) record(ts time.Time, direction Direction, raw []byte) error {
+	return r.commit(r.reserve(ts, direction, raw))
+}
+
+// reserve keeps the record's place, records behind it wait until it's committed or dropped
+func (r *Recorder) reserve(ts time.Time, direction Direction, raw []byte) *recordSlot {
+	b := make([]byte, recordingHeaderSize+len(raw))
+	binary.BigEndian.PutUint64(b[0:], uint64(ts.UnixNano()))
+	b[8] = byte(direction)
+	binary.BigEndian.PutUint32(b[9:], uint32(len(raw)))
+	copy(b[recordingHeaderSize:], raw)
+
+	slot := &recordSlot{b: b}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, slot)
+	return slot
+}
+
+func (r *Recorder) commit(slot *recordSlot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	slot.ready = true
+	return r.flush()
+}
+
+func (r *Recorder) drop(slot *recordSlot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, pending := range r.pending {
+		if pending == slot {
+			r.pending = append(r.pending[:i], r.pending[i+1:]...)
+			break
+		}
+	}
+
+	return r.flush()
+}
+
+func (r *Recorder) flush() error {
+	for len(r.pending) > 0 && r.pending[0].ready {
+		slot := r.pending[0]
+		r.pending[0] = nil
+		r.pending = r.pending[1:]
+
+		if r.f == nil {
+			return os.ErrClosed
+		}
+		if _, err := r.f.Write(slot.b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.f.Close()
+	r.f = nil
+	r.pending = nil
+	return err
+}
+
+func ReadRecording(path string) ([]*RecordedPdu, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic := make([]byte, len(recordingMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != recordingMagic {
+		return nil, fmt.Errorf("bad recording magic [%X]", magic)
+	}
+
+	var records []*RecordedPdu
+	header := make([]byte, recordingHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, nil
+			}
+			return records, err
+		}
+
+		raw := make([]byte, binary.BigEndian.Uint32(header[9:]))
+		if _, err := io.ReadFull(br, raw); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, nil
+			}
+			return records, err
+		}
+
+		records = append(records, &RecordedPdu{
+			Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[0:]))),
+			Direction: Direction(header[8]),
+			Raw:       raw,
+		})
+	}
+}
+
+type ReplayMismatchError struct {
+	Index    int
+	Expected *Pdu
+	Actual   *Pdu
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("replay mismatch at record [%v]: expected [%v], got [%v]", e.Index, e.Expected, e.Actual)
+}
+
+type Replayer struct {
+	records []*RecordedPdu
+	speed   float64
+}
+
+// NewReplayer: speed 1 keeps original timing, speed 10 replays 10 times faster, speed <= 0 replays without delays
+func NewReplayer(records []*RecordedPdu, speed float64) *Replayer {
+	return &Replayer{records: records, speed: speed}
+}
+
+func (r *Replayer) Run(ctx context.Context, conn net.Conn) error {
+	sock := NewSock(conn)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if len(r.records) == 0 {
+		return nil
+	}
+
+	start, origin := time.Now(), r.records[0].Time
+	seqs := make(map[uint32]uint32)
+	for i, record := range r.records {
+		expected, err := record.Pdu()
+		if err != nil && record.Direction != Inbound {
+			return fmt.Errorf("bad record [%v]: %w", i, err)
+		}
+
+		switch record.Direction {
+		case Inbound:
+			if r.speed > 0 {
+				delay := time.Duration(float64(record.Time.Sub(origin))/r.speed) - time.Since(start)
+				if delay > 0 {
+					select {
+					case <-time.After(delay):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+
+			// malformed frames are fed back unchanged
+			if err != nil {
+				if _, err := conn.Write(record.Raw); err != nil {
+					return r.err(ctx, err)
+				}
+				continue
+			}
+
+			if !expected.IsReq() {
+				if seq, ok := seqs[expected.Seq()]; ok {
+					expected.SetSeq(seq)
+				}
+			}
+
+			if err := sock.Write(expected); err != nil {
+				return r.err(ctx, err)
+			}
+		case Outbound:
+			actual, err := sock.Read()
+			if err != nil {
+				return r.err(ctx, err)
+			}
+
+			if !equalModuloSeq(expected.Serialize(), actual.Serialize()) {
+				return &ReplayMismatchError{Index: i, Expected: expected, Actual: actual}
+			}
+
+			if actual.IsReq() {
+				seqs[expected.Seq()] = actual.Seq()
+			}
+		default:
+			return fmt.Errorf("bad record [%v]: %v", i, record.Direction)
+		}
+	}
+
+	return nil
+}
+
+func (r *Replayer) err(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func equalModuloSeq(a, b []byte) bool {
+	if len(a) != len(b) || len(a) < 4*pduHeaderPartSize {
+		return false
+	}
+
+	return bytes.Equal(a[:3*pduHeaderPartSize], b[:3*pduHeaderPartSize]) &&
+		bytes.Equal(a[4*pduHeaderPartSize:], b[4*pduHeaderPartSize:])
+}
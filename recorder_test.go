@@ -0,0 +1,461 @@
+package zkm
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func runRecordedSession(sock *Sock) (*Session, func()) {
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	cfg.OutRpsLimit = 100
+	session := NewSessionWithConfig(sock, cfg, NewDefaultSpeedController(Robust))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.Run(ctx)
+	}()
+	go func() {
+		for range session.InEvtCh() {
+		}
+	}()
+
+	return session, func() {
+		cancel()
+		<-done
+	}
+}
+
+func runRecordedScenario(t *testing.T, session *Session, text string) error {
+	session.OutReqCh() <- &Req{Pdu: NewPdu(BindTransceiver)}
+	select {
+	case r := <-session.InRespCh():
+		if r.Err != nil {
+			return r.Err
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bind resp not received")
+	}
+
+	select {
+	case pdu := <-session.InReqCh():
+		resp, _ := pdu.CreateResp(EsmeROk)
+		session.OutRespCh() <- resp
+	case <-time.After(time.Second):
+		t.Fatal("deliver_sm not received")
+	}
+
+	submit := NewPdu(SubmitSm)
+	_ = submit.SetMain(ShortMessage, []byte(text))
+	_ = submit.SetMain(SMLength, len(text))
+	session.OutReqCh() <- &Req{Pdu: submit}
+	select {
+	case r := <-session.InRespCh():
+		return r.Err
+	case <-time.After(time.Second):
+		return ErrTimeout
+	}
+}
+
+func record(t *testing.T, path string) {
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	sock, peer := NewSock(local), NewSock(remote)
+	sock.SetRecorder(rec)
+
+	session, stop := runRecordedSession(sock)
+	defer stop()
+
+	go func() {
+		bind, err := peer.Read()
+		if err != nil {
+			return
+		}
+		resp, _ := bind.CreateResp(EsmeROk)
+		_ = peer.Write(resp)
+
+		deliver := NewPdu(DeliverSm)
+		deliver.SetSeq(100)
+		_ = deliver.SetMain(ShortMessage, []byte("hello"))
+		_ = deliver.SetMain(SMLength, 5)
+		_ = peer.Write(deliver)
+		_, _ = peer.Read()
+
+		submit, err := peer.Read()
+		if err != nil {
+			return
+		}
+		resp, _ = submit.CreateResp(EsmeROk)
+		_ = resp.SetMain(MessageID, "id-1")
+		_ = peer.Write(resp)
+	}()
+
+	if err := runRecordedScenario(t, session, "world"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.rec")
+	record(t, path)
+
+	records, err := ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		direction Direction
+		id        Id
+	}{
+		{Outbound, BindTransceiver},
+		{Inbound, BindTransceiverResp},
+		{Inbound, DeliverSm},
+		{Outbound, DeliverSmResp},
+		{Outbound, SubmitSm},
+		{Inbound, SubmitSmResp},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("records count [%v] not equals expected [%v]", len(records), len(expected))
+	}
+
+	for i, e := range expected {
+		pdu, err := records[i].Pdu()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if records[i].Direction != e.direction || pdu.Id() != e.id {
+			t.Errorf("record [%v] [%v %v] not equals expected [%v %v]", i, records[i].Direction, pdu.Id(), e.direction, e.id)
+		}
+		if i > 0 && records[i].Time.Before(records[i-1].Time) {
+			t.Errorf("record [%v] time [%v] is before previous [%v]", i, records[i].Time, records[i-1].Time)
+		}
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.rec")
+	record(t, path)
+
+	records, err := ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text     string
+		mismatch int
+	}{
+		{"world", -1},
+		{"other", 4},
+	}
+
+	for _, test := range tests {
+		local, remote := net.Pipe()
+		session, stop := runRecordedSession(NewSock(local))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		replayed := make(chan error, 1)
+		go func() {
+			replayed <- NewReplayer(records, 0).Run(ctx, remote)
+		}()
+
+		scenarioErr := runRecordedScenario(t, session, test.text)
+
+		err := <-replayed
+		if test.mismatch < 0 {
+			if scenarioErr != nil {
+				t.Errorf("[%v] scenario error [%v] not equals expected [nil]", test.text, scenarioErr)
+			}
+			if err != nil {
+				t.Errorf("[%v] replay error [%v] not equals expected [nil]", test.text, err)
+			}
+		} else {
+			mismatch := &ReplayMismatchError{}
+			if !errors.As(err, &mismatch) || mismatch.Index != test.mismatch {
+				t.Errorf("[%v] replay error [%v] not equals expected mismatch at [%v]", test.text, err, test.mismatch)
+			}
+		}
+
+		cancel()
+		remote.Close()
+		stop()
+	}
+}
+
+func TestReplayerTiming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.rec")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(100, 0)
+	for i := 0; i < 2; i++ {
+		req := NewPdu(EnquireLink)
+		req.SetSeq(uint32(i + 1))
+		resp, _ := req.CreateResp(EsmeROk)
+		ts := start.Add(time.Duration(i) * time.Second)
+		if err := rec.record(ts, Inbound, req.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+		if err := rec.record(ts, Outbound, resp.Serialize()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote := net.Pipe()
+	_, stop := runRecordedSession(NewSock(local))
+	defer stop()
+	defer remote.Close()
+
+	begin := time.Now()
+	if err := NewReplayer(records, 10).Run(context.Background(), remote); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(begin); elapsed < 100*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("elapsed [%v] not in expected range [100ms, 500ms]", elapsed)
+	}
+}
+
+func TestRecorderDropsFailedWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "session.rec")
+	rec, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := rec.reserve(time.Now(), Outbound, NewPdu(EnquireLink).Serialize())
+	if err := rec.Record(Inbound, NewPdu(DeliverSm)); err != nil {
+		t.Fatal(err)
+	}
+	sent := rec.reserve(time.Now(), Outbound, NewPdu(SubmitSm).Serialize())
+	if err := rec.Record(Inbound, NewPdu(SubmitSmResp)); err != nil {
+		t.Fatal(err)
+	}
+	_ = rec.drop(failed)
+	_ = rec.commit(sent)
+
+	local, remote := net.Pipe()
+	_ = remote.Close()
+	sock := NewSock(local)
+	sock.SetRecorder(rec)
+	if err := sock.Write(NewPdu(QuerySm)); err == nil {
+		t.Fatal("write to closed pipe not failed")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Id{DeliverSm, SubmitSm, SubmitSmResp}
+	if len(records) != len(expected) {
+		t.Fatalf("records count [%v] not equals expected [%v]", len(records), len(expected))
+	}
+	for i, id := range expected {
+		if pdu, err := records[i].Pdu(); err != nil || pdu.Id() != id {
+			t.Errorf("record [%v] [%v] not equals expected [%v]", i, pdu, id)
+		}
+	}
+}
+
+func TestServerRecorderFactory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(chan string, 2)
+	cfg := NewDefaultServerConfig()
+	cfg.RecorderFactory = func(conn net.Conn) *Recorder {
+		path := filepath.Join(dir, filepath.Base(conn.RemoteAddr().String())+".rec")
+		rec, err := NewRecorder(path)
+		if err != nil {
+			return nil
+		}
+		paths <- path
+		return rec
+	}
+	server := NewServerWithConfig(l, cfg)
+	go func() {
+		_ = server.Serve()
+	}()
+	go func() {
+		for range server.SessionCh() {
+		}
+	}()
+
+	for seq := uint32(1); seq <= 2; seq++ {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sock := NewSock(conn)
+		req := NewPdu(EnquireLink)
+		req.SetSeq(seq)
+		if err := sock.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sock.Read(); err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = server.Shutdown(ctx)
+	close(paths)
+
+	seqs := make(map[uint32]bool)
+	for path := range paths {
+		records, err := ReadRecording(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("[%v] records count [%v] not equals expected [2]", path, len(records))
+		}
+		req, _ := records[0].Pdu()
+		resp, _ := records[1].Pdu()
+		if records[0].Direction != Inbound || records[1].Direction != Outbound || req.Seq() != resp.Seq() {
+			t.Errorf("[%v] records [%v %v] not equals expected request and its response", path, req, resp)
+		}
+		seqs[req.Seq()] = true
+	}
+
+	if len(seqs) != 2 {
+		t.Errorf("recorded seqs [%v] not equals expected [1 2]", seqs)
+	}
+}
+
+func TestRecorderMalformedFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zkm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		frame    string
+		recorded string
+	}{
+		{"00000010000000040000000000000007", "00000010000000040000000000000007"},
+		{"00000004", "00000004"},
+	}
+
+	for _, test := range tests {
+		frame, _ := hex.DecodeString(test.frame)
+		recorded, _ := hex.DecodeString(test.recorded)
+
+		path := filepath.Join(dir, "session.rec")
+		rec, err := NewRecorder(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local, remote := net.Pipe()
+		sock := NewSock(local)
+		sock.SetRecorder(rec)
+		go func() {
+			_, _ = remote.Write(frame)
+		}()
+		if _, err := sock.Read(); err == nil {
+			t.Errorf("[%v] malformed frame read without error", test.frame)
+		}
+		local.Close()
+		remote.Close()
+		if err := rec.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := ReadRecording(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 || records[0].Direction != Inbound || !bytes.Equal(records[0].Raw, recorded) {
+			t.Errorf("[%v] records [%v] not equals expected [%X]", test.frame, records, recorded)
+			continue
+		}
+
+		local, remote = net.Pipe()
+		replayed := make(chan error, 1)
+		go func() {
+			replayed <- NewReplayer(records, 0).Run(context.Background(), remote)
+		}()
+
+		b := make([]byte, len(recorded))
+		if _, err := io.ReadFull(local, b); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, recorded) {
+			t.Errorf("[%v] replayed [%X] not equals expected [%X]", test.frame, b, recorded)
+		}
+		if err := <-replayed; err != nil {
+			t.Errorf("[%v] replay error [%v] not equals expected [nil]", test.frame, err)
+		}
+		local.Close()
+		remote.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package zkm
+
+import (
+	"math"
+	"time"
+)
+
+type RetryPolicy interface {
+	Retry(status Status, err error, attempt int32) (time.Duration, bool)
+}
+
+// RetryClassifier is optionally implemented by a RetryPolicy. It tells whether a failure is retryable
+// regardless of attempts, so a request which ran out of them goes to the dead letter sink.
+type RetryClassifier interface {
+	IsRetryable(status Status, err error) bool
+}
+
+var DefaultRetryStatuses = []Status{EsmeRThrottled, EsmeRMsgQFul, EsmeRxTAppn, EsmeRSysErr}
+
+type FixedDelayRetryPolicy struct {
+	Statuses      []Status
+	RetryTimeouts bool
+	MaxAttempts   int32
+	Delay         time.Duration
+}
+
+func NewFixedDelayRetryPolicy(maxAttempts int32, delay time.Duration) *FixedDelayRetryPolicy {
+	return &FixedDelayRetryPolicy{
+		Statuses:      DefaultRetryStatuses,
+		RetryTimeouts: true,
+		MaxAttempts:   maxAttempts,
+		Delay:         delay,
+	}
+}
+
+func (p *FixedDelayRetryPolicy) Retry(status Status, err error, attempt int32) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !isRetryable(p.Statuses, p.RetryTimeouts, status, err) {
+		return 0, false
+	}
+
+	return p.Delay, true
+}
+
+type ExponentialRetryPolicy struct {
+	Statuses      []Status
+	RetryTimeouts bool
+	MaxAttempts   int32
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Multiplier    float64
+}
+
+func NewExponentialRetryPolicy(maxAttempts int32, baseDelay, maxDelay time.Duration) *ExponentialRetryPolicy {
+	return &ExponentialRetryPolicy{
+		Statuses:      DefaultRetryStatuses,
+		RetryTimeouts: true,
+		MaxAttempts:   maxAttempts,
+		BaseDelay:     baseDelay,
+		MaxDelay:      maxDelay,
+		Multiplier:    2,
+	}
+}
+
+func (p *FixedDelayRetryPolicy) IsRetryable(status Status, err error) bool {
+	return isRetryable(p.Statuses, p.RetryTimeouts, status, err)
+}
+
+func (p *ExponentialRetryPolicy) Retry(status Status, err error, attempt int32) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !isRetryable(p.Statuses, p.RetryTimeouts, status, err) {
+		return 0, false
+	}
+
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(p.Multiplier, float64(attempt-1)))
+	if delay > p.MaxDelay || delay < 0 {
+		delay = p.MaxDelay
+	}
+
+	return delay, true
+}
+
+func (p *ExponentialRetryPolicy) IsRetryable(status Status, err error) bool {
+	return isRetryable(p.Statuses, p.RetryTimeouts, status, err)
+}
+
+func isRetryable(statuses []Status, retryTimeouts bool, status Status, err error) bool {
+	if err != nil {
+		return retryTimeouts && err == ErrTimeout
+	}
+
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
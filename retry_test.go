@@ -0,0 +1,105 @@
+package zkm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicies(t *testing.T) {
+	fixed := NewFixedDelayRetryPolicy(3, 100*time.Millisecond)
+	exponential := NewExponentialRetryPolicy(4, 100*time.Millisecond, 300*time.Millisecond)
+
+	tests := []struct {
+		policy        RetryPolicy
+		status        Status
+		err           error
+		attempt       int32
+		expectedDelay time.Duration
+		expectedRetry bool
+	}{
+		{policy: fixed, status: EsmeRThrottled, attempt: 1, expectedDelay: 100 * time.Millisecond, expectedRetry: true},
+		{policy: fixed, status: EsmeRMsgQFul, attempt: 2, expectedDelay: 100 * time.Millisecond, expectedRetry: true},
+		{policy: fixed, status: EsmeRMsgQFul, attempt: 3, expectedRetry: false},
+		{policy: fixed, status: EsmeRInvDstAdr, attempt: 1, expectedRetry: false},
+		{policy: fixed, err: ErrTimeout, attempt: 1, expectedDelay: 100 * time.Millisecond, expectedRetry: true},
+		{policy: fixed, err: ErrClosed, attempt: 1, expectedRetry: false},
+		{policy: exponential, status: EsmeRSysErr, attempt: 1, expectedDelay: 100 * time.Millisecond, expectedRetry: true},
+		{policy: exponential, status: EsmeRxTAppn, attempt: 2, expectedDelay: 200 * time.Millisecond, expectedRetry: true},
+		{policy: exponential, err: ErrTimeout, attempt: 3, expectedDelay: 300 * time.Millisecond, expectedRetry: true},
+		{policy: exponential, status: EsmeRThrottled, attempt: 4, expectedRetry: false},
+	}
+
+	for i, test := range tests {
+		delay, retry := test.policy.Retry(test.status, test.err, test.attempt)
+		if retry != test.expectedRetry || delay != test.expectedDelay {
+			t.Errorf("[%v] retry [%v][%v] not equals expected [%v][%v]",
+				i, retry, delay, test.expectedRetry, test.expectedDelay)
+		}
+	}
+}
+
+func TestSessionRetryPolicy(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.RetryPolicy = NewFixedDelayRetryPolicy(3, 10*time.Millisecond)
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(EnquireLink)}
+
+	for _, status := range []Status{EsmeRMsgQFul, EsmeRSysErr, EsmeROk} {
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := req.CreateResp(status)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case resp := <-session.InRespCh():
+		if resp.Err != nil || resp.Pdu.Status() != EsmeROk || resp.Req.retries != 3 {
+			t.Errorf("unexpected resp [%v][%v] after [%v] attempts", resp.Err, resp.Pdu, resp.Req.retries)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+}
+
+func TestSessionRetryPolicyThrottleRetriesCap(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.RetryPolicy = NewFixedDelayRetryPolicy(5, 0)
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(EnquireLink), ThrottleRetriesMaxCount: 2}
+
+	for i := 0; i < 2; i++ {
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _ := req.CreateResp(EsmeRThrottled)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case resp := <-session.InRespCh():
+		if resp.Err != nil || resp.Pdu.Status() != EsmeRThrottled || resp.Req.retries != 2 {
+			t.Errorf("unexpected resp [%v][%v] after [%v] attempts", resp.Err, resp.Pdu, resp.Req.retries)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+}
@@ -0,0 +1,64 @@
+package zkm
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+func (s *Session) Send(ctx context.Context, pdu *Pdu) (*Resp, error) {
+	req := &Req{
+		Pdu:     pdu,
+		respCh:  make(chan *Resp, 1),
+		sendCtx: ctx,
+	}
+
+	select {
+	case s.outReqCh <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, ErrClosed
+	}
+
+	select {
+	case resp := <-req.respCh:
+		return resp, resp.Err
+	case <-ctx.Done():
+		s.abandon(req)
+		return nil, ctx.Err()
+	case <-s.done:
+		select {
+		case resp := <-req.respCh:
+			return resp, resp.Err
+		default:
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (s *Session) SendAsync(ctx context.Context, pdu *Pdu, callback func(*Resp, error)) {
+	go func() {
+		callback(s.Send(ctx, pdu))
+	}()
+}
+
+func (s *Session) abandon(req *Req) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.reqsInFlight[req.seq]; !ok || r != req {
+		return
+	}
+
+	req.j.Cancel()
+	delete(s.reqsInFlight, req.seq)
+
+	outWin := atomic.AddInt32(&s.outWin, -1)
+	s.outWinChangedEvt(outWin)
+	if outWin < atomic.LoadInt32(&s.cfg.OutWinLimit) {
+		select {
+		case <-s.outWinSema:
+		default:
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package zkm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionSend(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	respond := func() {
+		req, err := peer.Read()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp, _ := req.CreateResp(EsmeROk)
+		if err := peer.Write(resp); err != nil {
+			t.Error(err)
+		}
+	}
+
+	go respond()
+	resp, err := session.Send(context.Background(), NewPdu(EnquireLink))
+	if err != nil || resp.Pdu.Id() != EnquireLinkResp {
+		t.Fatalf("unexpected resp [%v][%v]", resp, err)
+	}
+
+	go func() {
+		_, _ = peer.Read()
+	}()
+	ctx, cancelReq := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelReq()
+	if _, err := session.Send(ctx, NewPdu(EnquireLink)); !errors.Is(err, context.DeadlineExceeded) && err != ErrTimeout {
+		t.Fatalf("error [%v] not equals expected [%v]", err, context.DeadlineExceeded)
+	}
+
+	go respond()
+	result := make(chan error, 1)
+	session.SendAsync(context.Background(), NewPdu(EnquireLink), func(resp *Resp, err error) {
+		result <- err
+	})
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("unexpected error [%v]", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("window slot not freed after abandoned request")
+	}
+}
+
+func TestSessionSendWaitingForSpeedController(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 1
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	reqs := make(chan *Pdu, 10)
+	go func() {
+		for {
+			req, err := peer.Read()
+			if err != nil {
+				return
+			}
+			reqs <- req
+			resp, _ := req.CreateResp(EsmeROk)
+			_ = peer.Write(resp)
+		}
+	}()
+
+	if _, err := session.Send(context.Background(), NewPdu(EnquireLink)); err != nil {
+		t.Fatal(err)
+	}
+	<-reqs
+
+	ctx, cancelReq := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelReq()
+	if _, err := session.Send(ctx, NewPdu(EnquireLink)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error [%v] not equals expected [%v]", err, context.DeadlineExceeded)
+	}
+
+	if _, err := session.Send(context.Background(), NewPdu(EnquireLink)); err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 {
+		t.Errorf("sent reqs [%v] not equals expected [1], abandoned request was sent", len(reqs))
+	}
+	<-reqs
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := session.Send(context.Background(), NewPdu(EnquireLink))
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != ErrClosed {
+			t.Errorf("error [%v] not equals expected [%v]", err, ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send not completed after session closed")
+	}
+}
@@ -0,0 +1,229 @@
+package zkm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+type ServerConfig struct {
+	MaxConns               int32
+	TLSConfig              *tls.Config
+	TLSHandshakeTimeout    time.Duration
+	SessionConfigFactory   func(conn net.Conn) *SessionConfig
+	SpeedControllerFactory func(conn net.Conn) SpeedController
+	Capture                *PcapWriter
+	// RecorderFactory gives each connection its own recording, it's closed when the session ends
+	RecorderFactory func(conn net.Conn) *Recorder
+}
+
+func NewDefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		MaxConns:            0,
+		TLSHandshakeTimeout: DefaultTLSHandshakeTimeout,
+		SessionConfigFactory: func(net.Conn) *SessionConfig {
+			return NewDefaultSessionConfig()
+		},
+		SpeedControllerFactory: func(net.Conn) SpeedController {
+			return NewDefaultSpeedController(Robust)
+		},
+	}
+}
+
+type Server struct {
+	l             net.Listener
+	cfg           *ServerConfig
+	sessionCh     chan *Session
+	conns         int32
+	rejectedConns int64
+	closed        int32
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	handshakesWg  sync.WaitGroup
+	mu            sync.Mutex
+}
+
+func NewServer(l net.Listener) *Server {
+	return NewServerWithConfig(l, NewDefaultServerConfig())
+}
+
+func NewServerWithConfig(l net.Listener, cfg *ServerConfig) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		l:         l,
+		cfg:       cfg,
+		sessionCh: make(chan *Session, chanBuffSize),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (s *Server) Serve() error {
+	defer close(s.sessionCh)
+	defer s.handshakesWg.Wait()
+
+	var tempDelay time.Duration
+
+	for {
+		conn, err := s.l.Accept()
+
+		if err != nil {
+			if atomic.LoadInt32(&s.closed) != 0 {
+				return ErrServerClosed
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+
+				select {
+				case <-time.After(tempDelay):
+				case <-s.ctx.Done():
+					return ErrServerClosed
+				}
+				continue
+			}
+
+			return err
+		}
+
+		tempDelay = 0
+
+		if maxConns := atomic.LoadInt32(&s.cfg.MaxConns); maxConns > 0 && atomic.LoadInt32(&s.conns) >= maxConns {
+			atomic.AddInt64(&s.rejectedConns, 1)
+			_ = conn.Close()
+			continue
+		}
+
+		// the slot is reserved before the handshake, so concurrent handshakes can't exceed MaxConns
+		atomic.AddInt32(&s.conns, 1)
+
+		if s.cfg.TLSConfig != nil {
+			if !s.track(&s.handshakesWg) {
+				atomic.AddInt32(&s.conns, -1)
+				_ = conn.Close()
+				continue
+			}
+			go func() {
+				defer s.handshakesWg.Done()
+				tlsConn := tls.Server(conn, s.cfg.TLSConfig)
+				if err := handshakeTLS(tlsConn, s.cfg.TLSHandshakeTimeout); err != nil {
+					atomic.AddInt32(&s.conns, -1)
+					atomic.AddInt64(&s.rejectedConns, 1)
+					_ = conn.Close()
+					return
+				}
+				s.serveConn(tlsConn)
+			}()
+			continue
+		}
+
+		s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	sock := NewSock(conn)
+	if s.cfg.Capture != nil {
+		sock.SetCapture(s.cfg.Capture)
+	}
+	if !s.track(&s.wg) {
+		atomic.AddInt32(&s.conns, -1)
+		_ = conn.Close()
+		return
+	}
+
+	var recorder *Recorder
+	if s.cfg.RecorderFactory != nil {
+		if recorder = s.cfg.RecorderFactory(conn); recorder != nil {
+			sock.SetRecorder(recorder)
+		}
+	}
+	session := NewSessionWithConfig(sock, s.cfg.SessionConfigFactory(conn), s.cfg.SpeedControllerFactory(conn))
+
+	go func() {
+		defer s.wg.Done()
+		defer atomic.AddInt32(&s.conns, -1)
+		session.Run(s.ctx)
+		if recorder != nil {
+			_ = recorder.Close()
+		}
+	}()
+
+	select {
+	case s.sessionCh <- session:
+	case <-s.ctx.Done():
+	}
+}
+
+// track adds to wg unless Shutdown has started waiting on it.
+func (s *Server) track(wg *sync.WaitGroup) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return false
+	}
+
+	wg.Add(1)
+	return true
+}
+
+func (s *Server) SessionCh() <-chan *Session {
+	return s.sessionCh
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+func (s *Server) ConnCount() int32 {
+	return atomic.LoadInt32(&s.conns)
+}
+
+func (s *Server) RejectedConnCount() int64 {
+	return atomic.LoadInt64(&s.rejectedConns)
+}
+
+func (s *Server) SetMaxConns(maxConns int32) {
+	atomic.StoreInt32(&s.cfg.MaxConns, maxConns)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.mu.Lock()
+	closing := atomic.CompareAndSwapInt32(&s.closed, 0, 1)
+	s.mu.Unlock()
+	if closing {
+		err = s.l.Close()
+	}
+
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.handshakesWg.Wait()
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,84 @@
+package zkm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewDefaultServerConfig()
+	cfg.MaxConns = 1
+	server := NewServerWithConfig(l, cfg)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve()
+	}()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var session *Session
+	select {
+	case session = <-server.SessionCh():
+	case <-time.After(time.Second):
+		t.Fatal("session not accepted")
+	}
+
+	go func() {
+		for range session.InEvtCh() {
+		}
+	}()
+
+	sock := NewSock(conn)
+	req := NewPdu(EnquireLink)
+	req.SetSeq(1)
+	if err := sock.Write(req); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := sock.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id() != EnquireLinkResp || resp.Seq() != 1 || resp.Status() != EsmeROk {
+		t.Errorf("unexpected resp [%v]", resp)
+	}
+
+	rejected, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+
+	_ = rejected.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := NewSock(rejected).Read(); err == nil {
+		t.Error("connection over limit not rejected")
+	}
+	if server.RejectedConnCount() != 1 {
+		t.Errorf("rejected conns [%v] not equals expected [1]", server.RejectedConnCount())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("serve returned [%v], expected [%v]", err, ErrServerClosed)
+	}
+	if server.ConnCount() != 0 {
+		t.Errorf("conns [%v] not equals expected [0]", server.ConnCount())
+	}
+}
@@ -13,18 +13,35 @@ import (
 )
 
 const chanBuffSize = 10000
+const supervisionInterval = 100 * time.Millisecond
+const defaultHandlerWorkers = 16
+const defaultHandlerTimeout = time.Second
+
+var lastSessionId uint64
 
 var ErrTimeout = errors.New("timeout wait for response")
 var ErrClosed = errors.New("session closed")
+var ErrLinkDead = errors.New("enquire link unanswered")
+var ErrRetriesQueueFull = errors.New("queue of retries full")
 
+// Req.ThrottleRetriesMaxCount overrides the session's one, with a RetryPolicy it caps the policy's retries of throttled requests
 type Req struct {
-	Pdu       *Pdu
-	Trace     bool
-	TraceInfo string
-	j         *scheduler.Job
-	retries   int32
-	Ctx       interface{}
-	Sent      time.Time
+	Pdu                     *Pdu
+	Trace                   bool
+	TraceInfo               string
+	Timeout                 time.Duration
+	ThrottleRetriesMaxCount int32
+	Lane                    int32
+	j                       *scheduler.Job
+	retries                 int32
+	Ctx                     interface{}
+	Sent                    time.Time
+	respCh                  chan *Resp
+	sendCtx                 context.Context
+	seq                     uint32
+	laneIdx                 int
+	retryable               bool
+	pooled                  bool
 }
 
 type Resp struct {
@@ -51,6 +68,8 @@ const (
 type LogEvt struct {
 	severity Severity
 	msg      string
+	id       Id
+	seq      uint32
 }
 
 func (e *LogEvt) Severity() Severity {
@@ -61,6 +80,15 @@ func (e *LogEvt) Msg() string {
 	return e.msg
 }
 
+// Id is zero when the event is not about a pdu
+func (e *LogEvt) Id() Id {
+	return e.id
+}
+
+func (e *LogEvt) Seq() uint32 {
+	return e.seq
+}
+
 func (e *LogEvt) String() string {
 	return e.msg
 }
@@ -101,9 +129,22 @@ func (e *OutWinChangedEvt) Value() int32 {
 	return e.value
 }
 
+type OutRpsChangedEvt struct {
+	value int32
+}
+
+func (e *OutRpsChangedEvt) String() string {
+	return fmt.Sprintf("effective out rps changed to [%v]", e.value)
+}
+
+func (e *OutRpsChangedEvt) Value() int32 {
+	return e.value
+}
+
 type PduReceivedEvt struct {
 	id     Id
 	status Status
+	seq    uint32
 }
 
 func (e *PduReceivedEvt) String() string {
@@ -118,9 +159,14 @@ func (e *PduReceivedEvt) Status() Status {
 	return e.status
 }
 
+func (e *PduReceivedEvt) Seq() uint32 {
+	return e.seq
+}
+
 type PduSentEvt struct {
 	id     Id
 	status Status
+	seq    uint32
 }
 
 func (e *PduSentEvt) String() string {
@@ -135,6 +181,10 @@ func (e *PduSentEvt) Status() Status {
 	return e.status
 }
 
+func (e *PduSentEvt) Seq() uint32 {
+	return e.seq
+}
+
 type SpeedController interface {
 	Out(ctx context.Context) error
 	In() error
@@ -286,54 +336,110 @@ func (c *DefaultSpeedController) Run(ctx context.Context) {
 }
 
 type SessionConfig struct {
-	InRpsLimit              int32
-	OutRpsLimit             int32
-	InWinLimit              int32
-	OutWinLimit             int32
-	ThrottlePauseSec        int32
-	ThrottleRetriesMaxCount int32
-	ReqTimeoutSec           int32
-	EnquireLinkEnabled      bool
-	EnquireLinkIntervalSec  int64
-	SilenceTimeoutSec       int64
-	LogSeverity             Severity
+	InRpsLimit               int32
+	OutRpsLimit              int32
+	InWinLimit               int32
+	OutWinLimit              int32
+	ThrottlePause            time.Duration
+	ThrottleRetriesMaxCount  int32
+	ReqTimeout               time.Duration
+	RetryPolicy              RetryPolicy
+	EnquireLinkEnabled       bool
+	EnquireLinkInterval      time.Duration
+	EnquireLinkMaxUnanswered int32
+	SilenceTimeout           time.Duration
+	GracefulCloseEnabled     bool
+	DrainTimeout             time.Duration
+	LaneWeights              []int32
+	LaneScheduling           LaneScheduling
+	LaneQueueSize            int32
+	LaneByPriorityFlag       bool
+	DeadLetterSink           DeadLetterSink
+	OutReqInterceptors       []Interceptor
+	InReqInterceptors        []Interceptor
+	InRespInterceptors       []Interceptor
+	OutRespInterceptors      []Interceptor
+	Handler                  Handler
+	HandlerWorkers           int32
+	HandlerTimeout           time.Duration
+	EventSink                EventSink
+	EventSinkFactory         func(session *Session) EventSink
+	Metrics                  *SessionMetrics
+	LogSeverity              Severity
 }
 
 func NewDefaultSessionConfig() *SessionConfig {
 	return &SessionConfig{
-		InRpsLimit:              1,
-		OutRpsLimit:             1,
-		InWinLimit:              1,
-		OutWinLimit:             1,
-		ThrottlePauseSec:        1,
-		ThrottleRetriesMaxCount: 3,
-		ReqTimeoutSec:           2,
-		EnquireLinkEnabled:      false,
-		EnquireLinkIntervalSec:  15,
-		SilenceTimeoutSec:       60,
-		LogSeverity:             Info,
+		InRpsLimit:               1,
+		OutRpsLimit:              1,
+		InWinLimit:               1,
+		OutWinLimit:              1,
+		ThrottlePause:            time.Second,
+		ThrottleRetriesMaxCount:  3,
+		ReqTimeout:               2 * time.Second,
+		EnquireLinkEnabled:       false,
+		EnquireLinkInterval:      15 * time.Second,
+		EnquireLinkMaxUnanswered: 3,
+		SilenceTimeout:           60 * time.Second,
+		GracefulCloseEnabled:     false,
+		DrainTimeout:             5 * time.Second,
+		HandlerWorkers:           defaultHandlerWorkers,
+		HandlerTimeout:           defaultHandlerTimeout,
+		LogSeverity:              Info,
 	}
 }
 
+func loadDuration(d *time.Duration) time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(d)))
+}
+
+func storeDuration(d *time.Duration, value time.Duration) {
+	atomic.StoreInt64((*int64)(d), int64(value))
+}
+
 type Session struct {
-	sock            *Sock
-	scheduler       *scheduler.Scheduler
-	inReqCh         chan *Pdu
-	evtCh           chan Evt
-	outReqCh        chan *Req
-	outRespCh       chan *Pdu
-	inRespCh        chan *Resp
-	retriesCh       chan *Req
-	cfg             *SessionConfig
-	speedController SpeedController
-	inWin           int32
-	outWin          int32
-	outWinSema      chan struct{}
-	lastReading     int64
-	lastWriting     int64
-	reqsInFlight    map[uint32]*Req
-	lastThrottle    time.Time
-	mu              sync.Mutex
+	sock                  *Sock
+	scheduler             *scheduler.Scheduler
+	inReqCh               chan *Pdu
+	handlerReqCh          chan *Pdu
+	handlerWorkers        int32
+	evtCh                 chan Evt
+	outReqCh              chan *Req
+	outRespCh             chan *Pdu
+	inRespCh              chan *Resp
+	retriesCh             chan *Req
+	internalReqCh         chan *Req
+	deadLetterCh          chan *DeadLetter
+	lanes                 *lanes
+	outReqHandler         PduHandler
+	inReqHandler          PduHandler
+	inRespHandler         PduHandler
+	outRespHandler        PduHandler
+	counters              *sessionCounters
+	closing               chan struct{}
+	closingOnce           sync.Once
+	done                  chan struct{}
+	cancel                context.CancelFunc
+	cfg                   *SessionConfig
+	eventSink             EventSink
+	id                    uint64
+	speedController       SpeedController
+	inWin                 int32
+	outWin                int32
+	outWinSema            chan struct{}
+	lastReading           int64
+	lastWriting           int64
+	enquireLinkPending    int32
+	enquireLinkUnanswered int32
+	enquireLinkWg         sync.WaitGroup
+	unbindWg              sync.WaitGroup
+	reqsInFlight          map[uint32]*Req
+	delayedRetries        map[*Req]*scheduler.Job
+	lastThrottle          time.Time
+	mu                    sync.Mutex
+	state                 State
+	esme                  bool
+	stateMu               sync.Mutex
 }
 
 func NewSession(sock *Sock, speedController SpeedController) *Session {
@@ -342,7 +448,7 @@ func NewSession(sock *Sock, speedController SpeedController) *Session {
 
 func NewSessionWithConfig(sock *Sock, cfg *SessionConfig, speedController SpeedController) *Session {
 	speedController.SetRpsLimit(cfg.InRpsLimit, cfg.OutRpsLimit)
-	return &Session{
+	s := &Session{
 		sock:            sock,
 		scheduler:       scheduler.New(),
 		inReqCh:         make(chan *Pdu, chanBuffSize),
@@ -351,29 +457,67 @@ func NewSessionWithConfig(sock *Sock, cfg *SessionConfig, speedController SpeedC
 		outRespCh:       make(chan *Pdu, chanBuffSize),
 		inRespCh:        make(chan *Resp, chanBuffSize),
 		retriesCh:       make(chan *Req, chanBuffSize),
+		internalReqCh:   make(chan *Req, 1),
+		deadLetterCh:    make(chan *DeadLetter, chanBuffSize),
+		lanes:           newLanes(cfg),
+		closing:         make(chan struct{}),
+		done:            make(chan struct{}),
 		cfg:             cfg,
 		speedController: speedController,
 		outWinSema:      make(chan struct{}, 1),
-		lastReading:     time.Now().Unix(),
-		lastWriting:     time.Now().Unix(),
+		lastReading:     time.Now().UnixNano(),
+		lastWriting:     time.Now().UnixNano(),
 		reqsInFlight:    make(map[uint32]*Req),
+		delayedRetries:  make(map[*Req]*scheduler.Job),
+		counters:        newSessionCounters(),
+		id:              atomic.AddUint64(&lastSessionId, 1),
+	}
+
+	if cfg.Handler != nil {
+		s.handlerReqCh = make(chan *Pdu, chanBuffSize)
+		s.handlerWorkers = cfg.HandlerWorkers
+		if s.handlerWorkers <= 0 {
+			s.handlerWorkers = defaultHandlerWorkers
+		}
+	}
+
+	s.eventSink = cfg.EventSink
+	if cfg.EventSinkFactory != nil {
+		s.eventSink = cfg.EventSinkFactory(s)
 	}
+
+	s.outReqHandler = chainInterceptors(cfg.OutReqInterceptors, s.writeReq)
+	s.inReqHandler = chainInterceptors(cfg.InReqInterceptors, s.pushInReq)
+	s.inRespHandler = chainInterceptors(cfg.InRespInterceptors, s.handleIncomingResp)
+	s.outRespHandler = chainInterceptors(cfg.OutRespInterceptors, s.writePdu)
+
+	return s
 }
 
-func (s *Session) Run(ctx context.Context) {
+func (s *Session) Run(parentCtx context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.cancel = cancel
+
+	deadLettersDone := make(chan struct{})
+	go func() {
+		defer close(deadLettersDone)
+		s.handleDeadLetters()
+	}()
+
 	wg := sync.WaitGroup{}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		j := s.scheduler.Every(time.Second, func() {
+		j := s.scheduler.Every(supervisionInterval, func() {
 			now := time.Now()
 
-			if now.Unix()-atomic.LoadInt64(&s.lastReading) >= atomic.LoadInt64(&s.cfg.SilenceTimeoutSec) {
+			if time.Duration(now.UnixNano()-atomic.LoadInt64(&s.lastReading)) >= loadDuration(&s.cfg.SilenceTimeout) {
 				s.logEvt(Warning, func() string {
 					return fmt.Sprintf("silence timeout [%v] exceeded. Socket closing...",
-						atomic.LoadInt64(&s.cfg.SilenceTimeoutSec))
+						loadDuration(&s.cfg.SilenceTimeout))
 				})
 				if err := s.sock.Close(); err != nil {
 					s.logEvt(Error, func() string {
@@ -384,13 +528,11 @@ func (s *Session) Run(ctx context.Context) {
 				return
 			}
 
-			if s.cfg.EnquireLinkEnabled &&
-				now.Unix()-atomic.LoadInt64(&s.lastWriting) >= atomic.LoadInt64(&s.cfg.EnquireLinkIntervalSec) {
-				select {
-				case s.outReqCh <- &Req{
-					Pdu: NewPdu(EnquireLink),
-				}:
-				default:
+			if s.cfg.EnquireLinkEnabled {
+				interval := loadDuration(&s.cfg.EnquireLinkInterval)
+				if time.Duration(now.UnixNano()-atomic.LoadInt64(&s.lastWriting)) >= interval ||
+					time.Duration(now.UnixNano()-atomic.LoadInt64(&s.lastReading)) >= interval {
+					s.enquireLink(ctx)
 				}
 			}
 		})
@@ -417,14 +559,37 @@ func (s *Session) Run(ctx context.Context) {
 	go func() {
 		defer wg.Done()
 		s.handleIncomingPdus(ctx)
+		cancel()
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		s.handleOutgoingReqs(ctx)
+		if s.lanes != nil {
+			s.handleOutgoingLaneReqs(ctx)
+		} else {
+			s.handleOutgoingReqs(ctx)
+		}
 	}()
 
+	if s.lanes != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleIncomingLaneReqs(ctx)
+		}()
+	}
+
+	if s.handlerReqCh != nil {
+		for i := int32(0); i < s.handlerWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handleInReqs(ctx)
+			}()
+		}
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -432,22 +597,33 @@ func (s *Session) Run(ctx context.Context) {
 	}()
 
 	select {
-	case <-ctx.Done():
-		if err := s.sock.Close(); err != nil {
-			s.logEvt(Error, func() string {
-				return fmt.Sprintf("can't close socket: [%v]", err)
-			})
-			s.errEvt(err)
+	case <-parentCtx.Done():
+		if s.cfg.GracefulCloseEnabled {
+			s.unbind(ctx)
 		}
+		cancel()
+	case <-ctx.Done():
+	}
+
+	if err := s.sock.Close(); err != nil {
+		s.logEvt(Error, func() string {
+			return fmt.Sprintf("can't close socket: [%v]", err)
+		})
+		s.errEvt(err)
 	}
 
 	wg.Wait()
+	s.enquireLinkWg.Wait()
+	s.unbindWg.Wait()
 
 	s.mu.Lock()
-	jobs := make([]*scheduler.Job, 0, len(s.reqsInFlight))
+	jobs := make([]*scheduler.Job, 0, len(s.reqsInFlight)+len(s.delayedRetries))
 	for _, r := range s.reqsInFlight {
 		jobs = append(jobs, r.j)
 	}
+	for _, j := range s.delayedRetries {
+		jobs = append(jobs, j)
+	}
 	s.mu.Unlock()
 
 	for _, j := range jobs {
@@ -456,25 +632,60 @@ func (s *Session) Run(ctx context.Context) {
 
 	for _, r := range s.reqsInFlight {
 		_r := r
-		s.inRespCh <- &Resp{
+		s.deliverResp(&Resp{
+			Err: ErrClosed,
+			Req: _r,
+		})
+	}
+
+	for r := range s.delayedRetries {
+		_r := r
+		s.deliverResp(&Resp{
 			Err: ErrClosed,
 			Req: _r,
+		})
+	}
+
+	for len(s.retriesCh) > 0 {
+		s.deliverResp(&Resp{
+			Err: ErrClosed,
+			Req: <-s.retriesCh,
+		})
+	}
+
+	if s.lanes != nil {
+		for _, r := range s.lanes.drain() {
+			s.deliverResp(&Resp{
+				Err: ErrClosed,
+				Req: r,
+			})
 		}
 	}
 
+	close(s.deadLetterCh)
+	<-deadLettersDone
+
+	s.setState(Closed)
+
 	s.logEvt(Debug, func() string {
 		return "session completed"
 	})
 
+	close(s.done)
 	close(s.evtCh)
 	close(s.inRespCh)
 	close(s.inReqCh)
 }
 
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
 func (s *Session) InRespCh() <-chan *Resp {
 	return s.inRespCh
 }
 
+// InReqCh gets no requests while SessionConfig.Handler is set, the handler answers them.
 func (s *Session) InReqCh() <-chan *Pdu {
 	return s.inReqCh
 }
@@ -505,7 +716,7 @@ func (s *Session) handleOutgoingResponses(ctx context.Context) {
 
 			s.inWinChangedEvt(atomic.AddInt32(&s.inWin, -1))
 
-			err := s.sock.Write(pdu)
+			err := s.outRespHandler(ctx, pdu)
 
 			if err != nil {
 				s.logEvt(Error, func() string {
@@ -513,8 +724,9 @@ func (s *Session) handleOutgoingResponses(ctx context.Context) {
 				})
 				s.errEvt(err)
 			} else {
-				atomic.StoreInt64(&s.lastWriting, time.Now().Unix())
-				s.logEvt(Debug, func() string {
+				atomic.StoreInt64(&s.lastWriting, time.Now().UnixNano())
+				s.onRespSent(pdu)
+				s.pduLogEvt(Debug, pdu, func() string {
 					return fmt.Sprintf("sent pdu: [%v][%X]", pdu, pdu.Serialize())
 				})
 				s.pduSentEvt(pdu)
@@ -545,39 +757,68 @@ func (s *Session) handleIncomingPdus(ctx context.Context) {
 			})
 			s.errEvt(err)
 
-			if errors.Is(err, io.EOF) {
+			var pduErr *PduError
+			var netErr net.Error
+			if errors.As(err, &pduErr) {
+				if errors.Is(err, errInvalidCmdLen) {
+					s.nackFramingLoss(ctx, pduErr)
+					break
+				}
+				atomic.StoreInt64(&s.lastReading, time.Now().UnixNano())
+				if pduErr.Id&0x80000000 != 0 {
+					continue
+				}
+				s.inWinChangedEvt(atomic.AddInt32(&s.inWin, 1))
+				if !s.nack(ctx, pduErr.Seq, pduErr.Status) {
+					return
+				}
+				continue
+			} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr) {
 				break
 			} else {
 				continue
 			}
 		} else {
-			s.logEvt(Debug, func() string {
+			s.pduLogEvt(Debug, pdu, func() string {
 				return fmt.Sprintf("received pdu: [%v][%X]", pdu, pdu.Serialize())
 			})
 			s.pduReceivedEvt(pdu)
 		}
 
 		now := time.Now()
-		atomic.StoreInt64(&s.lastReading, now.Unix())
+		atomic.StoreInt64(&s.lastReading, now.UnixNano())
 
-		if pdu.IsReq() {
+		if pdu.IsReq() && !pdu.id.hasResp() {
+			if status := s.checkIncomingReq(pdu); status != EsmeROk {
+				s.logEvt(Warning, func() string {
+					return fmt.Sprintf("received pdu [%v] not allowed in state [%v]", pdu, s.State())
+				})
+			} else if err := s.inReqHandler(ctx, pdu); err != nil {
+				s.logEvt(Warning, func() string {
+					return fmt.Sprintf("intercepted pdu [%v]: [%v]", pdu, err)
+				})
+			}
+		} else if pdu.IsReq() {
 			inWin := atomic.AddInt32(&s.inWin, 1)
 			s.inWinChangedEvt(inWin)
-			if inWin <= atomic.LoadInt32(&s.cfg.InWinLimit) {
+			if !pdu.id.isKnown() {
+				s.logEvt(Warning, func() string {
+					return fmt.Sprintf("received pdu with unknown command id: [%v]", pdu)
+				})
+				if !s.nack(ctx, pdu.seq, EsmeRInvCmdId) {
+					return
+				}
+			} else if status := s.checkIncomingReq(pdu); status != EsmeROk {
+				s.logEvt(Warning, func() string {
+					return fmt.Sprintf("received pdu [%v] not allowed in state [%v]", pdu, s.State())
+				})
+				if !s.respond(ctx, pdu, status) {
+					return
+				}
+			} else if inWin <= atomic.LoadInt32(&s.cfg.InWinLimit) {
 				if err := s.speedController.In(); err == errThrottling {
-					resp, err := pdu.CreateResp(EsmeRThrottled)
-
-					if err != nil {
-						s.logEvt(Error, func() string {
-							return fmt.Sprintf("can't create resp for pdu: [%v]", pdu)
-						})
-						s.errEvt(err)
-					} else {
-						select {
-						case s.outRespCh <- resp:
-						case <-ctx.Done():
-							return
-						}
+					if !s.respond(ctx, pdu, EsmeRThrottled) {
+						return
 					}
 				} else if err != nil {
 					s.logEvt(Error, func() string {
@@ -585,120 +826,125 @@ func (s *Session) handleIncomingPdus(ctx context.Context) {
 					})
 					s.errEvt(err)
 
-					resp, err := pdu.CreateResp(EsmeRSysErr)
+					if !s.respond(ctx, pdu, EsmeRSysErr) {
+						return
+					}
+				} else {
+					if pdu.id == Unbind {
+						s.setState(Unbound)
+					}
 
-					if err != nil {
-						s.logEvt(Error, func() string {
-							return fmt.Sprintf("can't create resp for pdu: [%v]", pdu)
-						})
-						s.errEvt(err)
-					} else {
-						select {
-						case s.outRespCh <- resp:
-						case <-ctx.Done():
+					if pdu.id == Unbind && s.cfg.GracefulCloseEnabled {
+						s.unbindWg.Add(1)
+						go func() {
+							defer s.unbindWg.Done()
+							s.handleUnbind(ctx, pdu)
+						}()
+					} else if pdu.id == EnquireLink {
+						if !s.respond(ctx, pdu, EsmeROk) {
 							return
 						}
-					}
-				} else {
-					if pdu.id == EnquireLink {
-						resp, err := pdu.CreateResp(EsmeROk)
-
-						if err != nil {
-							s.logEvt(Error, func() string {
-								return fmt.Sprintf("can't create resp for pdu: [%v]", pdu)
-							})
-							s.errEvt(err)
-						} else {
-							select {
-							case s.outRespCh <- resp:
-							case <-ctx.Done():
-								return
-							}
+					} else if err := s.inReqHandler(ctx, pdu); err != nil {
+						s.logEvt(Warning, func() string {
+							return fmt.Sprintf("intercepted pdu [%v]: [%v]", pdu, err)
+						})
+						if !s.respond(ctx, pdu, interceptedStatus(err)) {
+							return
 						}
-					} else {
-						s.inReqCh <- pdu
 					}
 				}
 			} else {
-				resp, err := pdu.CreateResp(EsmeRThrottled)
-
-				if err != nil {
-					s.logEvt(Error, func() string {
-						return fmt.Sprintf("can't create resp for pdu: [%v]", pdu)
-					})
-					s.errEvt(err)
-				} else {
-					select {
-					case s.outRespCh <- resp:
-					case <-ctx.Done():
-						return
-					}
+				if !s.respond(ctx, pdu, EsmeRThrottled) {
+					return
 				}
 			}
-		} else {
-			func() {
-				s.mu.Lock()
-				defer s.mu.Unlock()
+		} else if err := s.inRespHandler(ctx, pdu); err != nil {
+			s.logEvt(Warning, func() string {
+				return fmt.Sprintf("intercepted pdu [%v]: [%v]", pdu, err)
+			})
+			s.interceptedResp(pdu, err)
+		}
+	}
+}
 
-				if pdu.status == EsmeRThrottled {
-					s.lastThrottle = now
-				}
+func (s *Session) handleIncomingResp(ctx context.Context, pdu *Pdu) error {
+	now := time.Now()
 
-				if req, ok := s.reqsInFlight[pdu.seq]; ok {
-					req.j.Cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-					if req.Trace {
-						s.logEvt(ForceDebug, func() string {
-							return fmt.Sprintf("[%v] received pdu: [%v][%X]", req.TraceInfo, pdu, pdu.Serialize())
-						})
-					}
+	if pdu.status == EsmeRThrottled {
+		s.lastThrottle = now
+	}
 
-					outWin := atomic.AddInt32(&s.outWin, -1)
-					s.outWinChangedEvt(outWin)
-					if outWin < atomic.LoadInt32(&s.cfg.OutWinLimit) {
-						select {
-						case <-s.outWinSema:
-						default:
-						}
-					}
+	if req, ok := s.reqsInFlight[pdu.seq]; ok {
+		req.j.Cancel()
 
-					if pdu.status == EsmeRThrottled && req.retries < atomic.LoadInt32(&s.cfg.ThrottleRetriesMaxCount) {
-						select {
-						case s.retriesCh <- req:
-							if req.Trace {
-								s.logEvt(ForceDebug, func() string {
-									return fmt.Sprintf("[%v] retry pdu[%v]: [%v][%X]", req.TraceInfo, req.retries, req.Pdu, req.Pdu.Serialize())
-								})
-							} else {
-								s.logEvt(Debug, func() string {
-									return fmt.Sprintf("retry pdu[%v]: [%v][%X]", req.retries, req.Pdu, req.Pdu.Serialize())
-								})
-							}
-						default:
-							s.errEvt(fmt.Errorf("queue of retries full: %v", len(s.retriesCh)))
-							s.inRespCh <- &Resp{
-								Pdu:      pdu,
-								Req:      req,
-								Received: now,
-							}
-						}
-					} else {
-						s.inRespCh <- &Resp{
-							Pdu:      pdu,
-							Req:      req,
-							Received: now,
-						}
-					}
+		s.onRespReceived(pdu)
 
-					delete(s.reqsInFlight, pdu.seq)
-				} else {
-					s.logEvt(Warning, func() string {
-						return fmt.Sprintf("received unexpected pdu: [%v]", pdu)
-					})
-				}
-			}()
+		if req.Trace {
+			s.pduLogEvt(ForceDebug, pdu, func() string {
+				return fmt.Sprintf("[%v] received pdu: [%v][%X]", req.TraceInfo, pdu, pdu.Serialize())
+			})
+		}
+
+		outWin := atomic.AddInt32(&s.outWin, -1)
+		s.outWinChangedEvt(outWin)
+		if outWin < atomic.LoadInt32(&s.cfg.OutWinLimit) {
+			select {
+			case <-s.outWinSema:
+			default:
+			}
 		}
+
+		s.feedback(pdu.status, nil)
+
+		if !s.retry(req, pdu.status, nil) {
+			s.deliverResp(&Resp{
+				Pdu:      pdu,
+				Req:      req,
+				Received: now,
+			})
+		}
+
+		delete(s.reqsInFlight, pdu.seq)
+	} else {
+		s.logEvt(Warning, func() string {
+			return fmt.Sprintf("received unexpected pdu: [%v]", pdu)
+		})
 	}
+
+	return nil
+}
+
+// interceptedResp completes the request answered by pdu with the interceptor's error
+func (s *Session) interceptedResp(pdu *Pdu, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.reqsInFlight[pdu.seq]
+	if !ok {
+		return
+	}
+
+	req.j.Cancel()
+	delete(s.reqsInFlight, pdu.seq)
+
+	outWin := atomic.AddInt32(&s.outWin, -1)
+	s.outWinChangedEvt(outWin)
+	if outWin < atomic.LoadInt32(&s.cfg.OutWinLimit) {
+		select {
+		case <-s.outWinSema:
+		default:
+		}
+	}
+
+	s.deliverResp(&Resp{
+		Err:      err,
+		Pdu:      pdu,
+		Req:      req,
+		Received: time.Now(),
+	})
 }
 
 func (s *Session) handleOutgoingReqs(ctx context.Context) {
@@ -707,15 +953,26 @@ func (s *Session) handleOutgoingReqs(ctx context.Context) {
 	})
 
 	var seq uint32
+	outReqCh := s.outReqCh
+	closing := s.closing
 
 	for {
 		select {
 		case s.outWinSema <- struct{}{}:
 			select {
+			case r := <-s.internalReqCh:
+				s.handleOutgoingReq(r, &seq, ctx)
 			case r := <-s.retriesCh:
 				s.handleOutgoingReq(r, &seq, ctx)
-			case r := <-s.outReqCh:
+			case r := <-outReqCh:
 				s.handleOutgoingReq(r, &seq, ctx)
+			case <-closing:
+				outReqCh = nil
+				closing = nil
+				select {
+				case <-s.outWinSema:
+				default:
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -726,18 +983,55 @@ func (s *Session) handleOutgoingReqs(ctx context.Context) {
 }
 
 func (s *Session) handleOutgoingReq(r *Req, seq *uint32, ctx context.Context) {
-	if err := s.speedController.Out(ctx); err != nil {
-		if errors.Is(err, context.Canceled) {
-			return
+	if r.sendCtx != nil && r.sendCtx.Err() != nil {
+		select {
+		case <-s.outWinSema:
+		default:
 		}
-		s.logEvt(Error, func() string {
-			return fmt.Sprintf("speed_controller.Out returned err: [%v]", err)
+		s.deliverResp(&Resp{
+			Err: r.sendCtx.Err(),
+			Req: r,
 		})
-		s.errEvt(err)
-		s.inRespCh <- &Resp{
+	} else if err := s.checkOutgoingReq(r.Pdu); err != nil {
+		s.logEvt(Warning, func() string {
+			return fmt.Sprintf("pdu [%v] not allowed in state [%v]", r.Pdu, s.State())
+		})
+		select {
+		case <-s.outWinSema:
+		default:
+		}
+		s.deliverResp(&Resp{
 			Err: err,
 			Req: r,
+		})
+	} else if wait, ok := s.tryOutPdu(r.Pdu); !ok {
+		select {
+		case <-s.outWinSema:
+		default:
+		}
+		s.park(r, wait)
+	} else if err := s.outReq(ctx, r); err != nil {
+		select {
+		case <-s.outWinSema:
+		default:
 		}
+
+		if ctx.Err() != nil {
+			err = ErrClosed
+		} else if r.sendCtx != nil && r.sendCtx.Err() != nil {
+			err = r.sendCtx.Err()
+		} else {
+			s.logEvt(Error, func() string {
+				return fmt.Sprintf("speed_controller.Out returned err: [%v]", err)
+			})
+			s.errEvt(err)
+		}
+		s.deliverResp(&Resp{
+			Err: err,
+			Req: r,
+		})
+	} else if !r.Pdu.id.hasResp() {
+		s.handleOutgoingReqWithoutResp(ctx, r, seq)
 	} else {
 		*seq++
 		_seq := *seq
@@ -747,7 +1041,8 @@ func (s *Session) handleOutgoingReq(r *Req, seq *uint32, ctx context.Context) {
 
 		s.mu.Lock()
 		r.retries++
-		r.j = s.scheduler.Once(time.Second*time.Duration(atomic.LoadInt32(&s.cfg.ReqTimeoutSec)), func() {
+		r.seq = _seq
+		r.j = s.scheduler.Once(s.reqTimeout(r, now), func() {
 			s.mu.Lock()
 			defer s.mu.Unlock()
 
@@ -761,14 +1056,20 @@ func (s *Session) handleOutgoingReq(r *Req, seq *uint32, ctx context.Context) {
 					}
 				}
 
-				s.inRespCh <- &Resp{
-					Err: ErrTimeout,
-					Req: req,
-				}
-				delete(s.reqsInFlight, _seq)
+				s.cfg.Metrics.timeout()
+				s.feedback(EsmeROk, ErrTimeout)
+				// logged before the retry, which may resend the pdu with a new seq
 				s.logEvt(Warning, func() string {
 					return fmt.Sprintf("req timeout exceeded for pdu [%v]", req.Pdu)
 				})
+
+				if !s.retry(req, EsmeROk, ErrTimeout) {
+					s.deliverResp(&Resp{
+						Err: ErrTimeout,
+						Req: req,
+					})
+				}
+				delete(s.reqsInFlight, _seq)
 			} else {
 				s.logEvt(Warning, func() string {
 					return fmt.Sprintf("req timeout exceeded for seq [%v], but req not found", _seq)
@@ -776,43 +1077,52 @@ func (s *Session) handleOutgoingReq(r *Req, seq *uint32, ctx context.Context) {
 			}
 		})
 		s.reqsInFlight[_seq] = r
-		throttlePause := time.Second*time.Duration(atomic.LoadInt32(&s.cfg.ThrottlePauseSec)) - now.Sub(s.lastThrottle)
+		// counted before writing, otherwise the response can be handled first and drive the window negative
+		outWin := atomic.AddInt32(&s.outWin, 1)
+		s.outWinChangedEvt(outWin)
+		throttlePause := loadDuration(&s.cfg.ThrottlePause) - now.Sub(s.lastThrottle)
 		r.Sent = now
 		s.mu.Unlock()
 
-		err := s.sock.Write(r.Pdu)
+		err := s.outReqHandler(ctx, r.Pdu)
 
 		if err != nil {
 			s.mu.Lock()
 			r.j.Cancel()
-			delete(s.reqsInFlight, _seq)
+			if _, ok := s.reqsInFlight[_seq]; ok {
+				delete(s.reqsInFlight, _seq)
+				s.outWinChangedEvt(atomic.AddInt32(&s.outWin, -1))
+			}
 			s.mu.Unlock()
 
-			s.logEvt(Error, func() string {
-				return fmt.Sprintf("can't write pdu [%v] to socket: [%v]", r.Pdu, err)
-			})
-			s.errEvt(err)
-			s.inRespCh <- &Resp{
-				Err: err,
-				Req: r,
+			select {
+			case <-s.outWinSema:
+			default:
 			}
+
+			s.deliverResp(&Resp{
+				Err: s.outReqErr(r.Pdu, err),
+				Req: r,
+			})
 		} else {
 			if r.Trace {
-				s.logEvt(ForceDebug, func() string {
+				s.pduLogEvt(ForceDebug, r.Pdu, func() string {
 					return fmt.Sprintf("[%v] sent pdu: [%v][%X]", r.TraceInfo, r.Pdu, r.Pdu.Serialize())
 				})
 			} else {
-				s.logEvt(Debug, func() string {
+				s.pduLogEvt(Debug, r.Pdu, func() string {
 					return fmt.Sprintf("sent pdu: [%v][%X]", r.Pdu, r.Pdu.Serialize())
 				})
 			}
 
+			atomic.StoreInt64(&s.lastWriting, now.UnixNano())
 			s.pduSentEvt(r.Pdu)
-			atomic.StoreInt64(&s.lastWriting, now.Unix())
 
-			outWin := atomic.AddInt32(&s.outWin, 1)
-			s.outWinChangedEvt(outWin)
-			if outWin < atomic.LoadInt32(&s.cfg.OutWinLimit) {
+			if r.Pdu.id == Unbind {
+				s.setState(Unbound)
+			}
+
+			if atomic.LoadInt32(&s.outWin) < atomic.LoadInt32(&s.cfg.OutWinLimit) {
 				select {
 				case <-s.outWinSema:
 				default:
@@ -830,32 +1140,495 @@ func (s *Session) handleOutgoingReq(r *Req, seq *uint32, ctx context.Context) {
 	}
 }
 
+func (s *Session) enquireLink(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&s.enquireLinkPending, 0, 1) {
+		return
+	}
+
+	req := &Req{
+		Pdu:    NewPdu(EnquireLink),
+		respCh: make(chan *Resp, 1),
+	}
+
+	select {
+	case s.internalReqCh <- req:
+	default:
+		atomic.StoreInt32(&s.enquireLinkPending, 0)
+		return
+	}
+
+	s.enquireLinkWg.Add(1)
+	go func() {
+		defer s.enquireLinkWg.Done()
+		defer atomic.StoreInt32(&s.enquireLinkPending, 0)
+
+		select {
+		case resp := <-req.respCh:
+			if resp.Err != ErrTimeout {
+				atomic.StoreInt32(&s.enquireLinkUnanswered, 0)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		unanswered := atomic.AddInt32(&s.enquireLinkUnanswered, 1)
+		s.logEvt(Warning, func() string {
+			return fmt.Sprintf("enquire link unanswered [%v] times", unanswered)
+		})
+
+		if unanswered >= atomic.LoadInt32(&s.cfg.EnquireLinkMaxUnanswered) {
+			s.logEvt(Error, func() string {
+				return "link is dead. Session closing..."
+			})
+			s.errEvt(ErrLinkDead)
+			s.cancel()
+		}
+	}()
+}
+
+func (s *Session) deliverResp(resp *Resp) {
+	s.cfg.Metrics.resp(resp)
+	s.deadLetter(resp)
+
+	if resp.Req != nil && resp.Req.respCh != nil {
+		select {
+		case resp.Req.respCh <- resp:
+		default:
+		}
+		return
+	}
+
+	s.inRespCh <- resp
+}
+
+func (s *Session) startClosing() {
+	s.closingOnce.Do(func() {
+		close(s.closing)
+	})
+}
+
+func (s *Session) drained(reservedInWin int32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.reqsInFlight) == 0 && len(s.retriesCh) == 0 && len(s.delayedRetries) == 0 &&
+		(s.lanes == nil || s.lanes.len() == 0) && atomic.LoadInt32(&s.inWin) <= reservedInWin
+}
+
+func (s *Session) waitDrained(ctx context.Context, reservedInWin int32) {
+	timer := time.NewTimer(loadDuration(&s.cfg.DrainTimeout))
+	defer timer.Stop()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !s.drained(reservedInWin) {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			s.logEvt(Warning, func() string {
+				return fmt.Sprintf("drain timeout [%v] exceeded", loadDuration(&s.cfg.DrainTimeout))
+			})
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Session) unbind(ctx context.Context) {
+	s.startClosing()
+	s.waitDrained(ctx, 0)
+
+	if !s.State().IsBound() {
+		return
+	}
+
+	req := &Req{
+		Pdu:    NewPdu(Unbind),
+		respCh: make(chan *Resp, 1),
+	}
+
+	select {
+	case s.internalReqCh <- req:
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case resp := <-req.respCh:
+		if resp.Err != nil {
+			s.logEvt(Warning, func() string {
+				return fmt.Sprintf("unbind failed: [%v]", resp.Err)
+			})
+		}
+	case <-ctx.Done():
+	}
+}
+
+func (s *Session) handleUnbind(ctx context.Context, pdu *Pdu) {
+	s.startClosing()
+	s.waitDrained(ctx, 1)
+	s.respond(ctx, pdu, EsmeROk)
+}
+
+func (s *Session) respond(ctx context.Context, pdu *Pdu, status Status) bool {
+	resp, err := pdu.CreateResp(status)
+
+	if err != nil {
+		s.logEvt(Error, func() string {
+			return fmt.Sprintf("can't create resp for pdu: [%v]", pdu)
+		})
+		s.errEvt(err)
+		return s.nack(ctx, pdu.seq, EsmeRInvCmdId)
+	}
+
+	select {
+	case s.outRespCh <- resp:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Session) nack(ctx context.Context, seq uint32, status Status) bool {
+	nack := NewPdu(GenericNack)
+	nack.SetSeq(seq)
+	nack.SetStatus(status)
+
+	select {
+	case s.outRespCh <- nack:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// nackFramingLoss writes the nack itself, the session closes right after it
+func (s *Session) nackFramingLoss(ctx context.Context, pduErr *PduError) {
+	nack := NewPdu(GenericNack)
+	nack.SetSeq(pduErr.Seq)
+	nack.SetStatus(pduErr.Status)
+
+	if err := s.outRespHandler(ctx, nack); err != nil {
+		s.errEvt(err)
+		return
+	}
+
+	atomic.StoreInt64(&s.lastWriting, time.Now().UnixNano())
+	s.pduSentEvt(nack)
+}
+
+func (s *Session) reqTimeout(r *Req, now time.Time) time.Duration {
+	timeout := loadDuration(&s.cfg.ReqTimeout)
+	if r.Timeout > 0 {
+		timeout = r.Timeout
+	}
+
+	if r.sendCtx != nil {
+		if deadline, ok := r.sendCtx.Deadline(); ok && deadline.Sub(now) < timeout {
+			timeout = deadline.Sub(now)
+		}
+	}
+
+	return timeout
+}
+
+// retry must be called with s.mu locked.
+func (s *Session) retry(req *Req, status Status, err error) bool {
+	var delay time.Duration
+	var ok bool
+
+	if req.Pdu.id == EnquireLink && err != nil {
+		return false
+	} else if s.cfg.RetryPolicy != nil {
+		delay, ok = s.cfg.RetryPolicy.Retry(status, err, req.retries)
+		if ok && err == nil && status == EsmeRThrottled && req.ThrottleRetriesMaxCount > 0 {
+			ok = req.retries < req.ThrottleRetriesMaxCount
+		}
+		if classifier, isClassifier := s.cfg.RetryPolicy.(RetryClassifier); isClassifier {
+			req.retryable = classifier.IsRetryable(status, err)
+		} else {
+			// without a classifier, a failure is known retryable only once the policy retried it
+			req.retryable = req.retryable || ok
+		}
+	} else {
+		req.retryable = err == nil && status == EsmeRThrottled
+		ok = req.retryable && req.retries < s.throttleRetriesMaxCount(req)
+	}
+
+	if !ok || (req.sendCtx != nil && req.sendCtx.Err() != nil) {
+		return false
+	}
+
+	if delay > 0 {
+		s.delayedRetries[req] = s.scheduler.Once(delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if _, ok := s.delayedRetries[req]; !ok {
+				return
+			}
+			delete(s.delayedRetries, req)
+
+			if !s.pushRetry(req) {
+				s.deliverResp(&Resp{
+					Err: ErrRetriesQueueFull,
+					Req: req,
+				})
+			}
+		})
+		return true
+	}
+
+	return s.pushRetry(req)
+}
+
+func (s *Session) pushRetry(req *Req) bool {
+	if s.requeue(req) {
+		s.cfg.Metrics.retry()
+		if req.Trace {
+			s.logEvt(ForceDebug, func() string {
+				return fmt.Sprintf("[%v] retry pdu[%v]: [%v][%X]", req.TraceInfo, req.retries, req.Pdu, req.Pdu.Serialize())
+			})
+		} else {
+			s.logEvt(Debug, func() string {
+				return fmt.Sprintf("retry pdu[%v]: [%v][%X]", req.retries, req.Pdu, req.Pdu.Serialize())
+			})
+		}
+		return true
+	}
+
+	return false
+}
+
+func (s *Session) requeue(req *Req) bool {
+	retriesCh := s.retriesCh
+	if s.lanes != nil {
+		retriesCh = s.lanes.chs[req.laneIdx]
+		defer s.lanes.notify()
+	}
+
+	select {
+	case retriesCh <- req:
+		return true
+	default:
+		s.errEvt(fmt.Errorf("queue of retries full: %v", len(retriesCh)))
+		return false
+	}
+}
+
+func (s *Session) throttleRetriesMaxCount(r *Req) int32 {
+	if r.ThrottleRetriesMaxCount > 0 {
+		return r.ThrottleRetriesMaxCount
+	}
+
+	return atomic.LoadInt32(&s.cfg.ThrottleRetriesMaxCount)
+}
+
+func (s *Session) handleOutgoingReqWithoutResp(ctx context.Context, r *Req, seq *uint32) {
+	*seq++
+	r.Pdu.SetSeq(*seq)
+
+	select {
+	case <-s.outWinSema:
+	default:
+	}
+
+	now := time.Now()
+	r.Sent = now
+	err := s.outReqHandler(ctx, r.Pdu)
+
+	if err != nil {
+		err = s.outReqErr(r.Pdu, err)
+	} else {
+		s.pduLogEvt(Debug, r.Pdu, func() string {
+			return fmt.Sprintf("sent pdu: [%v][%X]", r.Pdu, r.Pdu.Serialize())
+		})
+		atomic.StoreInt64(&s.lastWriting, now.UnixNano())
+		s.pduSentEvt(r.Pdu)
+	}
+
+	s.deliverResp(&Resp{
+		Err:      err,
+		Req:      r,
+		Received: now,
+	})
+}
+
 func (s *Session) logEvt(severity Severity, msgCreator func() string) {
 	if severity < s.cfg.LogSeverity {
 		return
 	}
 
-	s.evtCh <- &LogEvt{severity: severity, msg: msgCreator()}
+	s.emit(&LogEvt{severity: severity, msg: msgCreator()})
+}
+
+func (s *Session) pduLogEvt(severity Severity, pdu *Pdu, msgCreator func() string) {
+	if severity < s.cfg.LogSeverity {
+		return
+	}
+
+	s.emit(&LogEvt{severity: severity, msg: msgCreator(), id: pdu.id, seq: pdu.seq})
+}
+
+func (s *Session) emit(evt Evt) {
+	if s.eventSink != nil {
+		s.eventSink.Put(evt)
+		return
+	}
+
+	s.evtCh <- evt
 }
 
 func (s *Session) errEvt(err error) {
-	s.evtCh <- &ErrEvt{err: err}
+	s.emit(&ErrEvt{err: err})
 }
 
 func (s *Session) inWinChangedEvt(value int32) {
-	s.evtCh <- &InWinChangedEvt{value: value}
+	s.cfg.Metrics.inWinChanged(value)
+	s.emit(&InWinChangedEvt{value: value})
 }
 
 func (s *Session) outWinChangedEvt(value int32) {
-	s.evtCh <- &OutWinChangedEvt{value: value}
+	s.cfg.Metrics.outWinChanged(value)
+	s.emit(&OutWinChangedEvt{value: value})
 }
 
 func (s *Session) pduReceivedEvt(pdu *Pdu) {
-	s.evtCh <- &PduReceivedEvt{id: pdu.id, status: pdu.status}
+	s.cfg.Metrics.pduReceived(pdu)
+	s.counters.pduReceived(pdu)
+	s.emit(&PduReceivedEvt{id: pdu.id, status: pdu.status, seq: pdu.seq})
 }
 
 func (s *Session) pduSentEvt(pdu *Pdu) {
-	s.evtCh <- &PduSentEvt{id: pdu.id, status: pdu.status}
+	s.cfg.Metrics.pduSent(pdu)
+	s.counters.pduSent(pdu)
+	s.emit(&PduSentEvt{id: pdu.id, status: pdu.status, seq: pdu.seq})
+}
+
+// outReq waits for the speed controller until the session or the Send caller gives up.
+func (s *Session) outReq(ctx context.Context, r *Req) error {
+	if r.sendCtx == nil {
+		return s.speedController.Out(ctx)
+	}
+
+	outCtx, cancel := context.WithCancel(r.sendCtx)
+	defer cancel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	return s.speedController.Out(outCtx)
+}
+
+func (s *Session) tryOutPdu(pdu *Pdu) (time.Duration, bool) {
+	if c, ok := s.speedController.(PduSpeedController); ok {
+		return c.TryOutPdu(pdu)
+	}
+
+	return 0, true
+}
+
+// park puts aside a request whose pdu limit is exhausted, so requests with other keys aren't blocked behind it.
+func (s *Session) park(req *Req, wait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delayedRetries[req] = s.scheduler.Once(wait, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.delayedRetries[req]; !ok {
+			return
+		}
+		delete(s.delayedRetries, req)
+
+		if !s.requeue(req) {
+			s.deliverResp(&Resp{
+				Err: ErrRetriesQueueFull,
+				Req: req,
+			})
+		}
+	})
+}
+
+func (s *Session) feedback(status Status, err error) {
+	if c, ok := s.speedController.(AdaptiveSpeedController); ok {
+		if rate, changed := c.Feedback(status, err); changed {
+			s.emit(&OutRpsChangedEvt{value: rate})
+		}
+	}
+}
+
+func (s *Session) stateChangedEvt(from, to State) {
+	s.emit(&StateChangedEvt{from: from, to: to})
+}
+
+func (s *Session) State() State {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.state
+}
+
+func (s *Session) setState(state State) {
+	s.stateMu.Lock()
+	from := s.state
+	s.state = state
+	s.stateMu.Unlock()
+
+	if from != state {
+		s.stateChangedEvt(from, state)
+	}
+}
+
+func (s *Session) checkIncomingReq(pdu *Pdu) Status {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return checkState(s.state, pdu.id, !s.esme)
+}
+
+func (s *Session) checkOutgoingReq(pdu *Pdu) error {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if checkState(s.state, pdu.id, s.esme) != EsmeROk {
+		return ErrInvalidBindState
+	}
+	return nil
+}
+
+func (s *Session) onRespSent(pdu *Pdu) {
+	if pdu.id == UnbindResp && s.cfg.GracefulCloseEnabled {
+		s.cancel()
+		return
+	}
+
+	if state, ok := boundStateByBindResp(pdu.id); ok && pdu.status == EsmeROk {
+		s.stateMu.Lock()
+		s.esme = false
+		s.stateMu.Unlock()
+		s.setState(state)
+	}
+}
+
+func (s *Session) onRespReceived(pdu *Pdu) {
+	if state, ok := boundStateByBindResp(pdu.id); ok && pdu.status == EsmeROk {
+		s.stateMu.Lock()
+		s.esme = true
+		s.stateMu.Unlock()
+		s.setState(state)
+	}
+}
+
+// Id is unique per process, it tells apart sessions in logs
+func (s *Session) Id() uint64 {
+	return s.id
 }
 
 func (s *Session) RemoteAddr() net.Addr {
@@ -867,29 +1640,60 @@ func (s *Session) SetConfig(cfg *SessionConfig) {
 	atomic.StoreInt32(&s.cfg.OutRpsLimit, cfg.OutRpsLimit)
 	atomic.StoreInt32(&s.cfg.InWinLimit, cfg.InWinLimit)
 	atomic.StoreInt32(&s.cfg.OutWinLimit, cfg.OutWinLimit)
-	atomic.StoreInt32(&s.cfg.ThrottlePauseSec, cfg.ThrottlePauseSec)
+	storeDuration(&s.cfg.ThrottlePause, cfg.ThrottlePause)
 	atomic.StoreInt32(&s.cfg.ThrottleRetriesMaxCount, cfg.ThrottleRetriesMaxCount)
-	atomic.StoreInt32(&s.cfg.ReqTimeoutSec, cfg.ReqTimeoutSec)
+	storeDuration(&s.cfg.ReqTimeout, cfg.ReqTimeout)
+	s.mu.Lock()
+	s.cfg.RetryPolicy = cfg.RetryPolicy
+	s.mu.Unlock()
 	s.cfg.EnquireLinkEnabled = cfg.EnquireLinkEnabled
-	atomic.StoreInt64(&s.cfg.EnquireLinkIntervalSec, cfg.EnquireLinkIntervalSec)
-	atomic.StoreInt64(&s.cfg.SilenceTimeoutSec, cfg.SilenceTimeoutSec)
+	storeDuration(&s.cfg.EnquireLinkInterval, cfg.EnquireLinkInterval)
+	atomic.StoreInt32(&s.cfg.EnquireLinkMaxUnanswered, cfg.EnquireLinkMaxUnanswered)
+	storeDuration(&s.cfg.SilenceTimeout, cfg.SilenceTimeout)
+	s.cfg.GracefulCloseEnabled = cfg.GracefulCloseEnabled
+	storeDuration(&s.cfg.DrainTimeout, cfg.DrainTimeout)
+	atomic.StoreInt32(&s.cfg.LaneQueueSize, cfg.LaneQueueSize)
+	storeDuration(&s.cfg.HandlerTimeout, cfg.HandlerTimeout)
 	s.cfg.LogSeverity = cfg.LogSeverity
 
 	s.speedController.SetRpsLimit(cfg.InRpsLimit, cfg.OutRpsLimit)
 }
 
 func (s *Session) GetConfig() *SessionConfig {
+	s.mu.Lock()
+	retryPolicy := s.cfg.RetryPolicy
+	s.mu.Unlock()
+
 	return &SessionConfig{
-		InRpsLimit:              atomic.LoadInt32(&s.cfg.InRpsLimit),
-		OutRpsLimit:             atomic.LoadInt32(&s.cfg.OutRpsLimit),
-		InWinLimit:              atomic.LoadInt32(&s.cfg.InWinLimit),
-		OutWinLimit:             atomic.LoadInt32(&s.cfg.OutWinLimit),
-		ThrottlePauseSec:        atomic.LoadInt32(&s.cfg.ThrottlePauseSec),
-		ThrottleRetriesMaxCount: atomic.LoadInt32(&s.cfg.ThrottleRetriesMaxCount),
-		ReqTimeoutSec:           atomic.LoadInt32(&s.cfg.ReqTimeoutSec),
-		EnquireLinkEnabled:      s.cfg.EnquireLinkEnabled,
-		EnquireLinkIntervalSec:  atomic.LoadInt64(&s.cfg.EnquireLinkIntervalSec),
-		SilenceTimeoutSec:       atomic.LoadInt64(&s.cfg.SilenceTimeoutSec),
-		LogSeverity:             s.cfg.LogSeverity,
+		InRpsLimit:               atomic.LoadInt32(&s.cfg.InRpsLimit),
+		OutRpsLimit:              atomic.LoadInt32(&s.cfg.OutRpsLimit),
+		InWinLimit:               atomic.LoadInt32(&s.cfg.InWinLimit),
+		OutWinLimit:              atomic.LoadInt32(&s.cfg.OutWinLimit),
+		ThrottlePause:            loadDuration(&s.cfg.ThrottlePause),
+		ThrottleRetriesMaxCount:  atomic.LoadInt32(&s.cfg.ThrottleRetriesMaxCount),
+		ReqTimeout:               loadDuration(&s.cfg.ReqTimeout),
+		RetryPolicy:              retryPolicy,
+		EnquireLinkEnabled:       s.cfg.EnquireLinkEnabled,
+		EnquireLinkInterval:      loadDuration(&s.cfg.EnquireLinkInterval),
+		EnquireLinkMaxUnanswered: atomic.LoadInt32(&s.cfg.EnquireLinkMaxUnanswered),
+		SilenceTimeout:           loadDuration(&s.cfg.SilenceTimeout),
+		GracefulCloseEnabled:     s.cfg.GracefulCloseEnabled,
+		DrainTimeout:             loadDuration(&s.cfg.DrainTimeout),
+		LaneWeights:              append([]int32(nil), s.cfg.LaneWeights...),
+		LaneScheduling:           s.cfg.LaneScheduling,
+		LaneQueueSize:            atomic.LoadInt32(&s.cfg.LaneQueueSize),
+		LaneByPriorityFlag:       s.cfg.LaneByPriorityFlag,
+		DeadLetterSink:           s.cfg.DeadLetterSink,
+		OutReqInterceptors:       s.cfg.OutReqInterceptors,
+		InReqInterceptors:        s.cfg.InReqInterceptors,
+		InRespInterceptors:       s.cfg.InRespInterceptors,
+		OutRespInterceptors:      s.cfg.OutRespInterceptors,
+		Handler:                  s.cfg.Handler,
+		HandlerWorkers:           s.cfg.HandlerWorkers,
+		HandlerTimeout:           loadDuration(&s.cfg.HandlerTimeout),
+		EventSink:                s.cfg.EventSink,
+		EventSinkFactory:         s.cfg.EventSinkFactory,
+		Metrics:                  s.cfg.Metrics,
+		LogSeverity:              s.cfg.LogSeverity,
 	}
 }
@@ -0,0 +1,286 @@
+package zkm
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestSession(cfg *SessionConfig) (*Session, *Sock, func(), <-chan struct{}) {
+	local, remote := net.Pipe()
+
+	session := NewSessionWithConfig(NewSock(local), cfg, NewDefaultSpeedController(Robust))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer remote.Close()
+		session.Run(ctx)
+	}()
+	go func() {
+		for range session.InEvtCh() {
+		}
+	}()
+
+	return session, NewSock(remote), cancel, done
+}
+
+func bindTestSession(t *testing.T, session *Session, peer *Sock) {
+	session.OutReqCh() <- &Req{Pdu: NewPdu(BindTransceiver)}
+
+	bind, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := bind.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-session.InRespCh():
+		if r.Err != nil || r.Pdu.Status() != EsmeROk {
+			t.Fatalf("bind failed: [%v][%v]", r.Err, r.Pdu)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bind resp not received")
+	}
+}
+
+func TestSessionGracefulClose(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.GracefulCloseEnabled = true
+	cfg.OutRpsLimit = 100
+	session, peer, cancel, done := newTestSession(cfg)
+	defer cancel()
+
+	bindTestSession(t, session, peer)
+
+	cancel()
+
+	unbind, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unbind.Id() != Unbind {
+		t.Fatalf("unexpected pdu [%v]", unbind)
+	}
+	resp, _ := unbind.CreateResp(EsmeROk)
+	if err := peer.Write(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("session not completed")
+	}
+}
+
+func TestSessionGracefulCloseByPeer(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.GracefulCloseEnabled = true
+	cfg.OutRpsLimit = 100
+	session, peer, cancel, done := newTestSession(cfg)
+	defer cancel()
+
+	bindTestSession(t, session, peer)
+
+	unbind := NewPdu(Unbind)
+	unbind.SetSeq(1)
+	if err := peer.Write(unbind); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id() != UnbindResp || resp.Seq() != 1 {
+		t.Fatalf("unexpected pdu [%v]", resp)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("session not completed")
+	}
+
+	if session.State() != Closed {
+		t.Errorf("state [%v] not equals expected [%v]", session.State(), Closed)
+	}
+}
+
+func TestSessionGenericNack(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	unknown := NewPdu(Id(0x00000077))
+	unknown.SetSeq(3)
+	if err := peer.Write(unknown); err != nil {
+		t.Fatal(err)
+	}
+
+	nack, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nack.Id() != GenericNack || nack.Status() != EsmeRInvCmdId || nack.Seq() != 3 {
+		t.Errorf("unexpected nack [%v]", nack)
+	}
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(EnquireLink)}
+	req, err := peer.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nack = NewPdu(GenericNack)
+	nack.SetSeq(req.Seq())
+	nack.SetStatus(EsmeRInvCmdLen)
+	if err := peer.Write(nack); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case resp := <-session.InRespCh():
+		if resp.Err != nil || resp.Pdu.Id() != GenericNack || resp.Pdu.Status() != EsmeRInvCmdLen {
+			t.Errorf("unexpected resp [%v][%v]", resp.Err, resp.Pdu)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+}
+
+func TestSessionClosesOnInvalidCmdLen(t *testing.T) {
+	tests := []string{"0000000800000004", "FFFFFFF000000004"}
+
+	for _, test := range tests {
+		cfg := NewDefaultSessionConfig()
+		cfg.OutRpsLimit = 100
+		_, peer, cancel, done := newTestSession(cfg)
+
+		raw, _ := hex.DecodeString(test)
+		go func() {
+			_, _ = peer.c.Write(raw)
+		}()
+
+		nack, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nack.Id() != GenericNack || nack.Status() != EsmeRInvCmdLen {
+			t.Errorf("[%v] nack [%v] not equals expected [%v]", test, nack, EsmeRInvCmdLen)
+		}
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("[%v] session not closed", test)
+		}
+		cancel()
+		<-done
+	}
+}
+
+func TestSessionReqTimeout(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.ReqTimeout = time.Minute
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	go func() {
+		_, _ = peer.Read()
+	}()
+
+	sent := time.Now()
+	session.OutReqCh() <- &Req{Pdu: NewPdu(EnquireLink), Timeout: 50 * time.Millisecond}
+
+	select {
+	case resp := <-session.InRespCh():
+		if resp.Err != ErrTimeout {
+			t.Errorf("error [%v] not equals expected [%v]", resp.Err, ErrTimeout)
+		}
+		if elapsed := time.Since(sent); elapsed > 500*time.Millisecond {
+			t.Errorf("timeout [%v] exceeded req timeout", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resp not received")
+	}
+}
+
+func TestSessionEnquireLinkSupervision(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	cfg.EnquireLinkEnabled = true
+	cfg.EnquireLinkInterval = 20 * time.Millisecond
+	cfg.EnquireLinkMaxUnanswered = 2
+	cfg.ReqTimeout = 50 * time.Millisecond
+	session := NewSessionWithConfig(NewSock(local), cfg, NewDefaultSpeedController(Robust))
+
+	go session.Run(context.Background())
+
+	linkDead := make(chan struct{})
+	go func() {
+		for evt := range session.InEvtCh() {
+			if e, ok := evt.(*ErrEvt); ok && e.Err() == ErrLinkDead {
+				close(linkDead)
+			}
+		}
+	}()
+
+	peer := NewSock(remote)
+	for i := 0; i < 3; i++ {
+		req, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Id() != EnquireLink {
+			t.Fatalf("unexpected pdu [%v]", req)
+		}
+		resp, _ := req.CreateResp(EsmeROk)
+		if err := peer.Write(resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case resp := <-session.InRespCh():
+		t.Fatalf("enquire link resp leaked [%v]", resp)
+	default:
+	}
+
+	go func() {
+		for {
+			if _, err := peer.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-session.Done():
+	case <-time.After(time.Second):
+		t.Fatal("session not closed")
+	}
+
+	select {
+	case <-linkDead:
+	case <-time.After(time.Second):
+		t.Fatal("link dead event not received")
+	}
+}
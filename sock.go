@@ -2,13 +2,35 @@ package zkm
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
+// errInvalidCmdLen means the stream lost its framing, nothing after it can be read as a pdu
+var errInvalidCmdLen = errors.New("invalid command length")
+
+type PduError struct {
+	Status Status
+	Id     Id
+	Seq    uint32
+	Err    error
+}
+
+func (e *PduError) Error() string {
+	return fmt.Sprintf("bad pdu with seq [%v]: [%v]: %v", e.Seq, e.Status, e.Err)
+}
+
+func (e *PduError) Unwrap() error {
+	return e.Err
+}
+
 type Sock struct {
-	c net.Conn
+	c        net.Conn
+	capture  *pcapFlow
+	recorder *Recorder
 }
 
 func NewSock(conn net.Conn) *Sock {
@@ -19,8 +41,44 @@ func (s *Sock) Close() error {
 	return s.c.Close()
 }
 
+func (s *Sock) SetCapture(w *PcapWriter) {
+	s.capture = newPcapFlow(w, s.c)
+}
+
+func (s *Sock) SetRecorder(r *Recorder) {
+	s.recorder = r
+}
+
 func (s *Sock) Write(pdu *Pdu) error {
-	_, err := s.c.Write(pdu.Serialize())
+	raw := pdu.Serialize()
+
+	// reserved before writing, otherwise the peer's answer can be recorded or captured first
+	var slot *recordSlot
+	if s.recorder != nil {
+		slot = s.recorder.reserve(time.Now(), Outbound, raw)
+	}
+	var packet *pcapSlot
+	if s.capture != nil {
+		packet = s.capture.reserve(true, raw)
+	}
+
+	_, err := s.c.Write(raw)
+
+	if slot != nil {
+		if err != nil {
+			_ = s.recorder.drop(slot)
+		} else {
+			_ = s.recorder.commit(slot)
+		}
+	}
+	if packet != nil {
+		if err != nil {
+			_ = s.capture.drop(packet)
+		} else {
+			_ = s.capture.commit(packet)
+		}
+	}
+
 	return err
 }
 
@@ -33,8 +91,22 @@ func (s *Sock) Read() (*Pdu, error) {
 	}
 
 	l := binary.BigEndian.Uint32(rawL)
+	if l < 4*pduHeaderPartSize || l > MaxPduLen {
+		if s.recorder != nil {
+			_ = s.recorder.record(time.Now(), Inbound, rawL)
+		}
+	}
 	if l < 4*pduHeaderPartSize {
-		return nil, fmt.Errorf("PDU too small: %d < %d", l, 4*pduHeaderPartSize)
+		return nil, &PduError{
+			Status: EsmeRInvCmdLen,
+			Err:    fmt.Errorf("%w: PDU too small: %d < %d", errInvalidCmdLen, l, 4*pduHeaderPartSize),
+		}
+	}
+	if l > MaxPduLen {
+		return nil, &PduError{
+			Status: EsmeRInvCmdLen,
+			Err:    fmt.Errorf("%w: PDU too big: %d > %d", errInvalidCmdLen, l, MaxPduLen),
+		}
 	}
 
 	b := make([]byte, l-pduHeaderPartSize)
@@ -44,11 +116,35 @@ func (s *Sock) Read() (*Pdu, error) {
 		return nil, err
 	}
 
+	raw := append(rawL, b...)
+
+	if s.capture != nil {
+		_ = s.capture.write(false, raw)
+	}
+	// raw bytes, so frames that don't parse are recorded too
+	if s.recorder != nil {
+		_ = s.recorder.record(time.Now(), Inbound, raw)
+	}
+
 	pdu := NewEmptyPdu()
-	err = pdu.Deserialize(append(rawL, b...))
+	err = pdu.Deserialize(raw)
 
 	if err != nil {
-		return nil, err
+		id := Id(binary.BigEndian.Uint32(raw[pduHeaderPartSize:]))
+		pduErr := &PduError{
+			Status: EsmeRInvCmdLen,
+			Id:     id,
+			Seq:    binary.BigEndian.Uint32(raw[3*pduHeaderPartSize:]),
+			Err:    err,
+		}
+
+		if !id.isKnown() {
+			pduErr.Status = EsmeRInvCmdId
+		} else if errors.Is(err, errInvalidMsgLen) {
+			pduErr.Status = EsmeRInvMsgLen
+		}
+
+		return nil, pduErr
 	}
 
 	return pdu, nil
@@ -0,0 +1,66 @@
+package zkm
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSockReadPduError(t *testing.T) {
+	badSm := NewPdu(SubmitSm)
+	_ = badSm.SetMain(SMLength, 10)
+	_ = badSm.SetMain(ShortMessage, []byte{0x31, 0x32})
+	badSm.SetSeq(7)
+
+	tests := []struct {
+		raw            string
+		expectedStatus Status
+		expectedSeq    uint32
+	}{
+		{
+			raw:            "0000000800000004",
+			expectedStatus: EsmeRInvCmdLen,
+			expectedSeq:    0,
+		},
+		{
+			raw:            "FFFFFFF000000004",
+			expectedStatus: EsmeRInvCmdLen,
+			expectedSeq:    0,
+		},
+		{
+			raw:            "000000120000007700000000000000050000",
+			expectedStatus: EsmeRInvCmdId,
+			expectedSeq:    5,
+		},
+		{
+			raw:            hex.EncodeToString(badSm.Serialize()),
+			expectedStatus: EsmeRInvMsgLen,
+			expectedSeq:    7,
+		},
+	}
+
+	for i, test := range tests {
+		raw, err := hex.DecodeString(test.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local, remote := net.Pipe()
+		go func() {
+			_, _ = remote.Write(raw)
+		}()
+
+		_, err = NewSock(local).Read()
+		var pduErr *PduError
+		if !errors.As(err, &pduErr) {
+			t.Errorf("[%v] error [%v] is not pdu error", i, err)
+		} else if pduErr.Status != test.expectedStatus || pduErr.Seq != test.expectedSeq {
+			t.Errorf("[%v] status [%v] seq [%v] not equals expected [%v][%v]",
+				i, pduErr.Status, pduErr.Seq, test.expectedStatus, test.expectedSeq)
+		}
+
+		_ = local.Close()
+		_ = remote.Close()
+	}
+}
@@ -0,0 +1,290 @@
+package zkm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type limiter interface {
+	take(now time.Time) (time.Duration, bool)
+	setLimit(limit int32)
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit, burst int32, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   float64(limit),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) setLimit(limit int32) {
+	b.rate = float64(limit)
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.rate > 0 && b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	if b.rate <= 0 {
+		return time.Second, false
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+}
+
+type slidingWindow struct {
+	limit  int
+	events []time.Time
+}
+
+func newSlidingWindow(limit int32) *slidingWindow {
+	return &slidingWindow{limit: int(limit)}
+}
+
+func (w *slidingWindow) setLimit(limit int32) {
+	w.limit = int(limit)
+}
+
+func (w *slidingWindow) take(now time.Time) (time.Duration, bool) {
+	for len(w.events) > 0 && now.Sub(w.events[0]) >= time.Second {
+		w.events = w.events[1:]
+	}
+
+	if len(w.events) < w.limit {
+		w.events = append(w.events, now)
+		return 0, true
+	}
+
+	if len(w.events) == 0 {
+		return time.Second, false
+	}
+
+	return w.events[0].Add(time.Second).Sub(now), false
+}
+
+type limiterSpeedController struct {
+	in    limiter
+	out   limiter
+	clock Clock
+	mu    sync.Mutex
+}
+
+func (c *limiterSpeedController) Out(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		wait, ok := c.out.take(c.clock.Now())
+		c.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-c.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *limiterSpeedController) In() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.in.take(c.clock.Now()); !ok {
+		return errThrottling
+	}
+
+	return nil
+}
+
+func (c *limiterSpeedController) SetRpsLimit(in, out int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.in.setLimit(in)
+	c.out.setLimit(out)
+}
+
+func (c *limiterSpeedController) Run(ctx context.Context) {
+	<-ctx.Done()
+}
+
+type TokenBucketSpeedController struct {
+	limiterSpeedController
+}
+
+func NewTokenBucketSpeedController(burst int32) *TokenBucketSpeedController {
+	return NewTokenBucketSpeedControllerWithClock(burst, realClock{})
+}
+
+func NewTokenBucketSpeedControllerWithClock(burst int32, clock Clock) *TokenBucketSpeedController {
+	now := clock.Now()
+	return &TokenBucketSpeedController{
+		limiterSpeedController: limiterSpeedController{
+			in:    newTokenBucket(1, burst, now),
+			out:   newTokenBucket(1, burst, now),
+			clock: clock,
+		},
+	}
+}
+
+type SlidingWindowSpeedController struct {
+	limiterSpeedController
+}
+
+func NewSlidingWindowSpeedController() *SlidingWindowSpeedController {
+	return NewSlidingWindowSpeedControllerWithClock(realClock{})
+}
+
+func NewSlidingWindowSpeedControllerWithClock(clock Clock) *SlidingWindowSpeedController {
+	return &SlidingWindowSpeedController{
+		limiterSpeedController: limiterSpeedController{
+			in:    newSlidingWindow(1),
+			out:   newSlidingWindow(1),
+			clock: clock,
+		},
+	}
+}
+
+type AdaptiveSpeedController interface {
+	SpeedController
+	Feedback(status Status, err error) (int32, bool)
+}
+
+type AIMDSpeedController struct {
+	limiterSpeedController
+	bucket           *tokenBucket
+	minRate          float64
+	maxRate          float64
+	rate             float64
+	increase         float64
+	decreaseFactor   float64
+	decreaseInterval time.Duration
+	lastDecrease     time.Time
+	reported         int32
+}
+
+func NewAIMDSpeedController(minRps, increaseRps int32, decreaseFactor float64) *AIMDSpeedController {
+	return NewAIMDSpeedControllerWithClock(minRps, increaseRps, decreaseFactor, realClock{})
+}
+
+func NewAIMDSpeedControllerWithClock(minRps, increaseRps int32, decreaseFactor float64, clock Clock) *AIMDSpeedController {
+	if minRps < 1 {
+		minRps = 1
+	}
+
+	now := clock.Now()
+	bucket := newTokenBucket(minRps, 1, now)
+	return &AIMDSpeedController{
+		limiterSpeedController: limiterSpeedController{
+			in:    newTokenBucket(1, 1, now),
+			out:   bucket,
+			clock: clock,
+		},
+		bucket:           bucket,
+		minRate:          float64(minRps),
+		maxRate:          float64(minRps),
+		increase:         float64(increaseRps),
+		decreaseFactor:   decreaseFactor,
+		decreaseInterval: time.Second,
+	}
+}
+
+func (c *AIMDSpeedController) SetRpsLimit(in, out int32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.in.setLimit(in)
+	c.maxRate = float64(out)
+	if c.maxRate < c.minRate {
+		c.maxRate = c.minRate
+	}
+	if c.rate == 0 || c.rate > c.maxRate {
+		c.setRate(c.maxRate)
+	}
+}
+
+func (c *AIMDSpeedController) EffectiveOutRpsLimit() int32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return int32(c.rate)
+}
+
+func (c *AIMDSpeedController) Feedback(status Status, err error) (int32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == ErrTimeout || status == EsmeRThrottled || status == EsmeRMsgQFul {
+		now := c.clock.Now()
+		if now.Sub(c.lastDecrease) < c.decreaseInterval {
+			return c.reported, false
+		}
+		c.lastDecrease = now
+		c.setRate(c.rate * c.decreaseFactor)
+	} else if err == nil && c.rate < c.maxRate {
+		c.setRate(c.rate + c.increase/c.rate)
+	}
+
+	if rate := int32(c.rate); rate != c.reported {
+		c.reported = rate
+		return rate, true
+	}
+
+	return c.reported, false
+}
+
+func (c *AIMDSpeedController) setRate(rate float64) {
+	if rate < c.minRate {
+		rate = c.minRate
+	}
+	if rate > c.maxRate {
+		rate = c.maxRate
+	}
+	c.rate = rate
+	c.bucket.rate = rate
+}
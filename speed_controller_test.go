@@ -0,0 +1,198 @@
+package zkm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Advance(d)
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+type speedMeasure struct {
+	rate    float64
+	burst   int
+	instant int
+}
+
+func measureSpeed(start time.Time, times []time.Time, duration time.Duration) speedMeasure {
+	var m speedMeasure
+	steady := 0
+	for i, t := range times {
+		if t.Equal(start) {
+			m.instant++
+		}
+		if t.Sub(start) >= time.Second && t.Sub(start) < duration {
+			steady++
+		}
+		n := 0
+		for _, u := range times[i:] {
+			if u.Sub(t) < time.Second {
+				n++
+			}
+		}
+		if n > m.burst {
+			m.burst = n
+		}
+	}
+	m.rate = float64(steady) / (duration - time.Second).Seconds()
+	return m
+}
+
+func measureOut(t *testing.T, c SpeedController, clock *fakeClock, duration time.Duration) speedMeasure {
+	start := clock.Now()
+	var times []time.Time
+	for clock.Now().Sub(start) < duration {
+		if err := c.Out(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, clock.Now())
+	}
+	return measureSpeed(start, times, duration)
+}
+
+func measureIn(c SpeedController, clock *fakeClock, offered int, duration time.Duration) speedMeasure {
+	start := clock.Now()
+	var times []time.Time
+	for clock.Now().Sub(start) < duration {
+		for i := 0; i < offered/10; i++ {
+			if c.In() == nil {
+				times = append(times, clock.Now())
+			}
+		}
+		clock.Advance(100 * time.Millisecond)
+	}
+	return measureSpeed(start, times, duration)
+}
+
+func TestSpeedControllers(t *testing.T) {
+	const limit, burst = 10, 5
+	tests := []struct {
+		name     string
+		create   func(clock Clock) SpeedController
+		instant  int
+		maxBurst int
+	}{
+		{"token bucket", func(clock Clock) SpeedController {
+			return NewTokenBucketSpeedControllerWithClock(burst, clock)
+		}, burst, limit + burst},
+		{"sliding window", func(clock Clock) SpeedController {
+			return NewSlidingWindowSpeedControllerWithClock(clock)
+		}, limit, limit},
+	}
+
+	check := func(name, dir string, m speedMeasure, instant, maxBurst int) {
+		if m.rate < limit-1 || m.rate > limit+1 {
+			t.Errorf("%v %v rate [%v] not equals expected [%v]", name, dir, m.rate, limit)
+		}
+		if m.burst > maxBurst {
+			t.Errorf("%v %v burst [%v] exceeds expected [%v]", name, dir, m.burst, maxBurst)
+		}
+		if m.instant != instant {
+			t.Errorf("%v %v instant burst [%v] not equals expected [%v]", name, dir, m.instant, instant)
+		}
+	}
+
+	for _, test := range tests {
+		clock := newFakeClock()
+		c := test.create(clock)
+		c.SetRpsLimit(limit, limit)
+		check(test.name, "out", measureOut(t, c, clock, 10*time.Second), test.instant, test.maxBurst)
+
+		clock = newFakeClock()
+		c = test.create(clock)
+		c.SetRpsLimit(limit, limit)
+		check(test.name, "in", measureIn(c, clock, 10*limit, 10*time.Second), test.instant, test.maxBurst)
+	}
+}
+
+func TestSpeedControllerOutCanceled(t *testing.T) {
+	c := NewTokenBucketSpeedController(1)
+	c.SetRpsLimit(1, 1)
+
+	if err := c.Out(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Out(ctx); err != context.DeadlineExceeded {
+		t.Errorf("error [%v] not equals expected [%v]", err, context.DeadlineExceeded)
+	}
+}
+
+func TestAIMDSpeedController(t *testing.T) {
+	clock := newFakeClock()
+	c := NewAIMDSpeedControllerWithClock(5, 10, 0.5, clock)
+	c.SetRpsLimit(10, 100)
+
+	if rate := c.EffectiveOutRpsLimit(); rate != 100 {
+		t.Errorf("rate [%v] not equals expected [100]", rate)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		status  Status
+		err     error
+		rate    int32
+		changed bool
+	}{
+		{0, EsmeRThrottled, nil, 50, true},
+		{0, EsmeRThrottled, nil, 50, false},
+		{time.Second, EsmeROk, ErrTimeout, 25, true},
+		{time.Second, EsmeRMsgQFul, nil, 12, true},
+		{time.Second, EsmeRThrottled, nil, 6, true},
+		{time.Second, EsmeRThrottled, nil, 5, true},
+		{0, EsmeROk, nil, 7, true},
+		{0, EsmeRInvDstAdr, nil, 8, true},
+	}
+
+	for i, test := range tests {
+		clock.Advance(test.advance)
+		rate, changed := c.Feedback(test.status, test.err)
+		if rate != test.rate || changed != test.changed {
+			t.Errorf("%v: feedback [%v][%v] not equals expected [%v][%v]", i, rate, changed, test.rate, test.changed)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		c.Feedback(EsmeROk, nil)
+	}
+	if rate := c.EffectiveOutRpsLimit(); rate != 100 {
+		t.Errorf("rate [%v] not equals expected [100]", rate)
+	}
+
+	start := clock.Now()
+	for i := 0; i < 3; i++ {
+		c.Out(context.Background())
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 20*time.Millisecond {
+		t.Errorf("elapsed [%v] not equals expected [%v]", elapsed, 20*time.Millisecond)
+	}
+}
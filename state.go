@@ -0,0 +1,109 @@
+package zkm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidBindState = errors.New("command not allowed in current bind state")
+
+type State int32
+
+const (
+	Open State = iota
+	BoundTx
+	BoundRx
+	BoundTrx
+	Unbound
+	Closed
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "OPEN"
+	case BoundTx:
+		return "BOUND_TX"
+	case BoundRx:
+		return "BOUND_RX"
+	case BoundTrx:
+		return "BOUND_TRX"
+	case Unbound:
+		return "UNBOUND"
+	case Closed:
+		return "CLOSED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (s State) IsBound() bool {
+	return s == BoundTx || s == BoundRx || s == BoundTrx
+}
+
+type StateChangedEvt struct {
+	from State
+	to   State
+}
+
+func (e *StateChangedEvt) String() string {
+	return fmt.Sprintf("state changed from [%v] to [%v]", e.from, e.to)
+}
+
+func (e *StateChangedEvt) From() State {
+	return e.from
+}
+
+func (e *StateChangedEvt) To() State {
+	return e.to
+}
+
+func boundStateByBindResp(id Id) (State, bool) {
+	switch id {
+	case BindTransmitterResp:
+		return BoundTx, true
+	case BindReceiverResp:
+		return BoundRx, true
+	case BindTransceiverResp:
+		return BoundTrx, true
+	default:
+		return Open, false
+	}
+}
+
+func isBind(id Id) bool {
+	return id == BindTransmitter || id == BindReceiver || id == BindTransceiver
+}
+
+// checkState reports the status the request must be rejected with in the given state,
+// or EsmeROk if the request is allowed. fromEsme tells who issued the request.
+func checkState(state State, id Id, fromEsme bool) Status {
+	switch state {
+	case Open:
+		if isBind(id) || id == Outbind || id == EnquireLink {
+			return EsmeROk
+		}
+		return EsmeRInvBndSts
+	case BoundTx, BoundRx, BoundTrx:
+		if isBind(id) || id == Outbind {
+			return EsmeRAlyBnd
+		}
+	default:
+		return EsmeRInvBndSts
+	}
+
+	switch id {
+	case EnquireLink, Unbind, DataSm:
+		return EsmeROk
+	case SubmitSm, SubmitMulti, QuerySm, CancelSm, ReplaceSm:
+		if fromEsme && state != BoundRx {
+			return EsmeROk
+		}
+	case DeliverSm, AlertNotification:
+		if !fromEsme && state != BoundTx {
+			return EsmeROk
+		}
+	}
+
+	return EsmeRInvBndSts
+}
@@ -0,0 +1,119 @@
+package zkm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckState(t *testing.T) {
+	tests := []struct {
+		state    State
+		id       Id
+		fromEsme bool
+		expected Status
+	}{
+		{state: Open, id: BindTransceiver, fromEsme: true, expected: EsmeROk},
+		{state: Open, id: Outbind, fromEsme: false, expected: EsmeROk},
+		{state: Open, id: EnquireLink, fromEsme: true, expected: EsmeROk},
+		{state: Open, id: SubmitSm, fromEsme: true, expected: EsmeRInvBndSts},
+		{state: BoundTx, id: BindTransmitter, fromEsme: true, expected: EsmeRAlyBnd},
+		{state: BoundTx, id: SubmitSm, fromEsme: true, expected: EsmeROk},
+		{state: BoundTx, id: DeliverSm, fromEsme: false, expected: EsmeRInvBndSts},
+		{state: BoundRx, id: SubmitSm, fromEsme: true, expected: EsmeRInvBndSts},
+		{state: BoundRx, id: DeliverSm, fromEsme: false, expected: EsmeROk},
+		{state: BoundTrx, id: SubmitSm, fromEsme: true, expected: EsmeROk},
+		{state: BoundTrx, id: DeliverSm, fromEsme: false, expected: EsmeROk},
+		{state: BoundTrx, id: SubmitSm, fromEsme: false, expected: EsmeRInvBndSts},
+		{state: BoundTrx, id: Unbind, fromEsme: false, expected: EsmeROk},
+		{state: Unbound, id: EnquireLink, fromEsme: true, expected: EsmeRInvBndSts},
+		{state: Closed, id: SubmitSm, fromEsme: true, expected: EsmeRInvBndSts},
+	}
+
+	for i, test := range tests {
+		if status := checkState(test.state, test.id, test.fromEsme); status != test.expected {
+			t.Errorf("[%v] status [%v] not equals expected [%v]", i, status, test.expected)
+		}
+	}
+}
+
+func TestSessionBindState(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	cfg := NewDefaultSessionConfig()
+	cfg.InRpsLimit = 100
+	session := NewSessionWithConfig(NewSock(local), cfg, NewDefaultSpeedController(Robust))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		session.Run(ctx)
+	}()
+
+	states := make(chan State, 10)
+	go func() {
+		for evt := range session.InEvtCh() {
+			if e, ok := evt.(*StateChangedEvt); ok {
+				states <- e.To()
+			}
+		}
+		close(states)
+	}()
+
+	peer := NewSock(remote)
+	roundTrip := func(id Id, seq uint32) *Pdu {
+		req := NewPdu(id)
+		req.SetSeq(seq)
+		if err := peer.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := peer.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := roundTrip(SubmitSm, 1); resp.Status() != EsmeRInvBndSts {
+		t.Errorf("status [%v] not equals expected [%v]", resp.Status(), EsmeRInvBndSts)
+	}
+
+	bind := NewPdu(BindTransceiver)
+	bind.SetSeq(2)
+	if err := peer.Write(bind); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case pdu := <-session.InReqCh():
+		resp, _ := pdu.CreateResp(EsmeROk)
+		session.OutRespCh() <- resp
+	case <-time.After(time.Second):
+		t.Fatal("bind not received")
+	}
+	if resp, err := peer.Read(); err != nil || resp.Id() != BindTransceiverResp {
+		t.Fatalf("unexpected bind resp [%v][%v]", resp, err)
+	}
+
+	select {
+	case state := <-states:
+		if state != BoundTrx {
+			t.Errorf("state [%v] not equals expected [%v]", state, BoundTrx)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("state not changed")
+	}
+
+	if resp := roundTrip(BindTransceiver, 3); resp.Status() != EsmeRAlyBnd {
+		t.Errorf("status [%v] not equals expected [%v]", resp.Status(), EsmeRAlyBnd)
+	}
+
+	cancel()
+	<-done
+
+	if session.State() != Closed {
+		t.Errorf("state [%v] not equals expected [%v]", session.State(), Closed)
+	}
+}
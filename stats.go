@@ -0,0 +1,128 @@
+package zkm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Stats struct {
+	State          State
+	InWin          int32
+	OutWin         int32
+	InFlight       int
+	DelayedRetries int
+	RetriesQueue   int
+	LanesQueue     int
+	InReqQueue     int
+	OutRespQueue   int
+	LastReading    time.Time
+	LastWriting    time.Time
+	Sent           map[Id]uint64
+	Received       map[Id]uint64
+	InRps          int32
+	OutRps         int32
+}
+
+type rateMeter struct {
+	sec     int64
+	current int32
+	last    int32
+}
+
+func (m *rateMeter) add(now time.Time) {
+	m.roll(now)
+	m.current++
+}
+
+func (m *rateMeter) rate(now time.Time) int32 {
+	m.roll(now)
+	return m.last
+}
+
+func (m *rateMeter) roll(now time.Time) {
+	sec := now.Unix()
+	switch {
+	case sec == m.sec:
+	case sec == m.sec+1:
+		m.last, m.current = m.current, 0
+	default:
+		m.last, m.current = 0, 0
+	}
+	m.sec = sec
+}
+
+type sessionCounters struct {
+	sent     map[Id]uint64
+	received map[Id]uint64
+	inRate   rateMeter
+	outRate  rateMeter
+	mu       sync.Mutex
+}
+
+func newSessionCounters() *sessionCounters {
+	return &sessionCounters{
+		sent:     make(map[Id]uint64),
+		received: make(map[Id]uint64),
+	}
+}
+
+func (c *sessionCounters) pduSent(pdu *Pdu) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent[pdu.id]++
+	if pdu.IsReq() {
+		c.outRate.add(time.Now())
+	}
+}
+
+func (c *sessionCounters) pduReceived(pdu *Pdu) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.received[pdu.id]++
+	if pdu.IsReq() {
+		c.inRate.add(time.Now())
+	}
+}
+
+func (s *Session) Stats() *Stats {
+	stats := &Stats{
+		State:        s.State(),
+		InWin:        atomic.LoadInt32(&s.inWin),
+		OutWin:       atomic.LoadInt32(&s.outWin),
+		RetriesQueue: len(s.retriesCh),
+		InReqQueue:   len(s.inReqCh),
+		OutRespQueue: len(s.outRespCh),
+		LastReading:  time.Unix(0, atomic.LoadInt64(&s.lastReading)),
+		LastWriting:  time.Unix(0, atomic.LoadInt64(&s.lastWriting)),
+	}
+
+	if s.lanes != nil {
+		stats.LanesQueue = s.lanes.len()
+	}
+
+	s.mu.Lock()
+	stats.InFlight = len(s.reqsInFlight)
+	stats.DelayedRetries = len(s.delayedRetries)
+	s.mu.Unlock()
+
+	now := time.Now()
+	c := s.counters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats.Sent = make(map[Id]uint64, len(c.sent))
+	for id, n := range c.sent {
+		stats.Sent[id] = n
+	}
+	stats.Received = make(map[Id]uint64, len(c.received))
+	for id, n := range c.received {
+		stats.Received[id] = n
+	}
+	stats.InRps = c.inRate.rate(now)
+	stats.OutRps = c.outRate.rate(now)
+
+	return stats
+}
@@ -0,0 +1,86 @@
+package zkm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	start := time.Unix(100, 0)
+	m := rateMeter{}
+
+	tests := []struct {
+		offset   time.Duration
+		add      int
+		expected int32
+	}{
+		{0, 5, 0},
+		{500 * time.Millisecond, 3, 0},
+		{time.Second, 2, 8},
+		{2 * time.Second, 0, 2},
+		{4 * time.Second, 0, 0},
+	}
+
+	for _, test := range tests {
+		now := start.Add(test.offset)
+		for i := 0; i < test.add; i++ {
+			m.add(now)
+		}
+		if rate := m.rate(now); rate != test.expected {
+			t.Errorf("[%v] rate [%v] not equals expected [%v]", test.offset, rate, test.expected)
+		}
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	cfg := NewDefaultSessionConfig()
+	cfg.OutRpsLimit = 100
+	session, peer, cancel, done := newTestSession(cfg)
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	stop := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				session.Stats()
+			}
+		}
+	}()
+
+	bindTestSession(t, session, peer)
+
+	session.OutReqCh() <- &Req{Pdu: NewPdu(SubmitSm)}
+	if _, err := peer.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stats *Stats
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if stats = session.Stats(); stats.OutWin == 1 && stats.Sent[SubmitSm] == 1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	close(stop)
+	<-polled
+
+	if stats.State != BoundTrx {
+		t.Errorf("state [%v] not equals expected [%v]", stats.State, BoundTrx)
+	}
+	if stats.OutWin != 1 || stats.InFlight != 1 {
+		t.Errorf("out window [%v], in flight [%v] not equals expected [1]", stats.OutWin, stats.InFlight)
+	}
+	if stats.Sent[BindTransceiver] != 1 || stats.Sent[SubmitSm] != 1 || stats.Received[BindTransceiverResp] != 1 {
+		t.Errorf("unexpected totals: sent [%v], received [%v]", stats.Sent, stats.Received)
+	}
+	if stats.LastWriting.Before(stats.LastReading) {
+		t.Errorf("last writing [%v] before last reading [%v]", stats.LastWriting, stats.LastReading)
+	}
+}
@@ -0,0 +1,96 @@
+package zkm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+func handshakeTLS(conn *tls.Conn, timeout time.Duration) error {
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+func DialTLS(network, addr string, cfg *tls.Config, timeout time.Duration) (*Sock, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, clientTLSConfig(cfg, addr))
+	if err := handshakeTLS(tlsConn, timeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return NewSock(tlsConn), nil
+}
+
+func clientTLSConfig(cfg *tls.Config, addr string) *tls.Config {
+	if cfg.ServerName != "" || cfg.InsecureSkipVerify {
+		return cfg
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	cfg = cfg.Clone()
+	cfg.ServerName = host
+	return cfg
+}
+
+func (s *Sock) TLSConnectionState() (tls.ConnectionState, bool) {
+	if conn, ok := s.c.(*tls.Conn); ok {
+		return conn.ConnectionState(), true
+	}
+
+	return tls.ConnectionState{}, false
+}
+
+func (s *Session) TLSConnectionState() (tls.ConnectionState, bool) {
+	return s.sock.TLSConnectionState()
+}
+
+func (s *Session) PeerCertificates() []*x509.Certificate {
+	state, ok := s.sock.TLSConnectionState()
+	if !ok {
+		return nil
+	}
+
+	return state.PeerCertificates
+}
+
+func (s *Session) VerifiedChains() [][]*x509.Certificate {
+	state, ok := s.sock.TLSConnectionState()
+	if !ok {
+		return nil
+	}
+
+	return state.VerifiedChains
+}
+
+// PeerIdentity returns the common name of the verified peer certificate,
+// so it can be used instead of system_id and password on mutual TLS binds.
+func (s *Session) PeerIdentity() (string, bool) {
+	chains := s.VerifiedChains()
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", false
+	}
+
+	return chains[0][0].Subject.CommonName, true
+}
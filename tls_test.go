@@ -0,0 +1,169 @@
+package zkm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func createTestCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	ca, caKey, _ := createTestCert(t, "ca", nil, nil)
+	_, _, serverCert := createTestCert(t, "smsc", ca, caKey)
+	_, _, clientCert := createTestCert(t, "esme1", ca, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewDefaultServerConfig()
+	cfg.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server := NewServerWithConfig(l, cfg)
+	go func() {
+		_ = server.Serve()
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	sock, err := DialTLS("tcp", server.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	var session *Session
+	select {
+	case session = <-server.SessionCh():
+	case <-time.After(time.Second):
+		t.Fatal("session not accepted")
+	}
+
+	if identity, ok := session.PeerIdentity(); !ok || identity != "esme1" {
+		t.Errorf("identity [%v] not equals expected [esme1]", identity)
+	}
+
+	if state, ok := sock.TLSConnectionState(); !ok || state.PeerCertificates[0].Subject.CommonName != "smsc" {
+		t.Error("unexpected server certificate")
+	}
+
+	if anonymous, err := DialTLS("tcp", server.Addr().String(), &tls.Config{RootCAs: pool}, time.Second); err == nil {
+		defer anonymous.Close()
+		if _, err := anonymous.Read(); err == nil {
+			t.Error("connection without client certificate not rejected")
+		}
+	}
+	if server.ConnCount() != 1 {
+		t.Errorf("conns [%v] not equals expected [1]", server.ConnCount())
+	}
+}
+
+func TestServerMaxConnsDuringTLSHandshake(t *testing.T) {
+	ca, caKey, _ := createTestCert(t, "ca", nil, nil)
+	_, _, serverCert := createTestCert(t, "smsc", ca, caKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := NewDefaultServerConfig()
+	cfg.MaxConns = 1
+	cfg.TLSConfig = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	server := NewServerWithConfig(l, cfg)
+	go func() {
+		_ = server.Serve()
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if cond() {
+				return true
+			}
+		}
+		return false
+	}
+
+	handshaking, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { return server.ConnCount() == 1 }) {
+		t.Fatalf("conns [%v] not equals expected [1]", server.ConnCount())
+	}
+
+	rejected, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+	if !waitFor(func() bool { return server.RejectedConnCount() == 1 }) {
+		t.Errorf("rejected conns [%v] not equals expected [1]", server.RejectedConnCount())
+	}
+
+	_ = handshaking.Close()
+	if !waitFor(func() bool { return server.ConnCount() == 0 }) {
+		t.Errorf("conns [%v] not equals expected [0]", server.ConnCount())
+	}
+}